@@ -0,0 +1,157 @@
+package fsp
+
+import (
+	"math"
+)
+
+// Prices aggregates flights of some part of the problem
+type Prices struct {
+	Flights  int     `json:"flights"`
+	MinPrice Money   `json:"min_price"`
+	AvgPrice float64 `json:"avg_price"`
+	MaxPrice Money   `json:"max_price"`
+}
+
+func (p *Prices) add(cost Money) {
+	if p.Flights == 0 || cost < p.MinPrice {
+		p.MinPrice = cost
+	}
+	if cost > p.MaxPrice {
+		p.MaxPrice = cost
+	}
+	p.AvgPrice = (p.AvgPrice*float64(p.Flights) + float64(cost)) / float64(p.Flights+1)
+	p.Flights++
+}
+
+// Analysis describes characteristics of the problem, Name fields are left
+// for the caller who knows names of the cities
+type Analysis struct {
+	Cities int `json:"cities"`
+	Prices
+	// share of connections between cities that exist out of all that
+	// could, more flights between two cities on the same day count once
+	Density     float64              `json:"density"`
+	ByCity      []CityAnalysis       `json:"by_city"`
+	ByDay       []DayAnalysis        `json:"by_day"`
+	Bottlenecks []BottleneckAnalysis `json:"bottlenecks"`
+}
+
+// CityAnalysis aggregates flights departing from the city
+type CityAnalysis struct {
+	City City   `json:"city"`
+	Name string `json:"name,omitempty"`
+	Prices
+	Destinations int `json:"destinations"`
+	Days         int `json:"days"` // days with a departure
+	Arrivals     int `json:"arrivals"`
+}
+
+// DayAnalysis aggregates flights of the day, connectivity takes into
+// account only cities we can get to by that day
+type DayAnalysis struct {
+	Day Day `json:"day"`
+	Prices
+	// least destinations from any city we can be in at the start of the day,
+	// 0 means there is a dead end
+	MinOutDegree int `json:"min_out_degree"`
+	// cities we can be in after the flight of the day
+	Reachable int `json:"reachable"`
+}
+
+// BottleneckAnalysis is a city with few flights from or to it, routes have
+// to use one of them
+type BottleneckAnalysis struct {
+	City      City   `json:"city"`
+	Name      string `json:"name,omitempty"`
+	Direction string `json:"direction"` // "from" or "to"
+	Flights   int    `json:"flights"`
+}
+
+func (p Problem) Analyze() Analysis {
+	n := p.n
+	a := Analysis{Cities: n}
+	a.ByCity = make([]CityAnalysis, n)
+	a.ByDay = make([]DayAnalysis, n)
+	dests := make([][]bool, n)
+	days := make([][]bool, n)
+	for i := 0; i < n; i++ {
+		a.ByCity[i].City = City(i)
+		a.ByDay[i].Day = Day(i)
+		dests[i] = make([]bool, n)
+		days[i] = make([]bool, n)
+	}
+	for _, f := range p.flights {
+		if int(f.From) >= n || int(f.To) >= n || int(f.Day) >= n {
+			continue
+		}
+		a.add(f.Cost)
+		a.ByCity[f.From].add(f.Cost)
+		a.ByDay[f.Day].add(f.Cost)
+		a.ByCity[f.To].Arrivals++
+		if !dests[f.From][f.To] {
+			dests[f.From][f.To] = true
+			a.ByCity[f.From].Destinations++
+		}
+		if !days[f.From][f.Day] {
+			days[f.From][f.Day] = true
+			a.ByCity[f.From].Days++
+		}
+	}
+	// metrics describe flights as given, not what pruning leaves of them
+	g := unprunedGraph(p)
+	if n > 1 {
+		// n-1 connections on first and last day, (n-1)*(n-2) otherwise
+		possible := 2*(n-1) + (n-2)*(n-1)*(n-2)
+		// graph keeps only the cheapest of flights between two cities on a day
+		a.Density = float64(len(g.flights)) / float64(possible)
+	}
+	reach := g.reachable()
+	for day := 0; day < n; day++ {
+		a.ByDay[day].MinOutDegree = g.minOutDegree(Day(day), reach)
+		for _, r := range reach[day] {
+			if r {
+				a.ByDay[day].Reachable++
+			}
+		}
+	}
+
+	bs := collectBottlenecks(p)
+	for c, b := range bs.from {
+		if len(b) > 0 {
+			a.Bottlenecks = append(a.Bottlenecks, BottleneckAnalysis{City: City(c), Direction: "from", Flights: len(b)})
+		}
+	}
+	for c, b := range bs.to {
+		if len(b) > 0 {
+			a.Bottlenecks = append(a.Bottlenecks, BottleneckAnalysis{City: City(c), Direction: "to", Flights: len(b)})
+		}
+	}
+	return a
+}
+
+// least number of destinations from cities we can be in at the start of
+// the day
+func (g Graph) minOutDegree(day Day, reach [][]bool) int {
+	degree := math.MaxInt32
+	seen := make([]bool, g.size)
+	for c := 0; c < g.size; c++ {
+		if (day == 0 && City(c) != g.source) || (day > 0 && !reach[day-1][c]) {
+			continue
+		}
+		for i := range seen {
+			seen[i] = false
+		}
+		d := 0
+		for _, f := range g.fromDay(City(c), day) {
+			if !seen[f.To] {
+				seen[f.To] = true
+				d++
+			}
+		}
+		degree = min(degree, d)
+	}
+	if degree == math.MaxInt32 {
+		return 0
+	}
+	return degree
+}
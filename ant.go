@@ -4,272 +4,254 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
-	//"os"
-	"time"
-	//"sort"
-	//"github.com/pkg/profile"
 )
 
-// Freaky engine finding pseudo-ant paths
+// MAX-MIN Ant System, ants build routes choosing flights by feromones and
+// price, only the best route of an iteration or the best one so far leaves
+// feromones; they are kept between bounds so that no flight gets chosen
+// always or never
 type AntEngine struct {
-	graph Graph
-	seed  int
+	graph  Graph
+	seed   int64
+	params antParams
 }
 
-var feromones []float32
+// defaults of tunable parameters
+const EVAPORATE_P = 0.1 // share of feromones evaporating every iteration
+const FEROM_C = 1.0
+const PRICE_C = 2.0
 
-type ant struct {
-	day     Day
-	city    City
-	total   Money
-	visited []City
-	fis     []FlightIndex
-}
+// ants building routes in one iteration, fewer on small problems
+const antMaxAnts = 20
 
-const EVAPORATE_P = 0.7 // percent to evaporate
-const FEROM_C = 0.7
-const PRICE_C = 2.0
-const FEROMONE_WEIGHT = 0.9
+// ants choose from this many cheapest flights to unvisited cities
+const antCandidates = 8
 
-var ANTS = 0
-var ants []ant
+// every this many iterations the best route so far leaves feromones
+// instead of the best one of the iteration
+const antGlobalEvery = 5
+
+// iterations without better route after which feromones are reset
+const antStagnation = 100
+
+type antParams struct {
+	evaporate float64
+	feromC    float64
+	priceC    float64
+	// routes of ants are improved by city swaps before update
+	polish bool
+}
 
-var antSteps = 0
+func newAntParams(ps params) antParams {
+	return antParams{
+		evaporate: ps.get("ANT_EVAPORATE", EVAPORATE_P),
+		feromC:    ps.get("ANT_FEROM_C", FEROM_C),
+		priceC:    ps.get("ANT_PRICE_C", PRICE_C),
+		polish:    ps.get("ANT_POLISH", 1) != 0,
+	}
+}
 
-var antCurrentBest = Money(math.MaxInt32)
+// ants of one run of the engine
+type colony struct {
+	graph     Graph
+	feromones []float64
+	// desirability of flights by price, it does not change
+	price    []float64
+	max      float64
+	min      float64
+	best     []Flight
+	bestCost Money
+	rand     *rand.Rand
+	params   antParams
+	// buffers of the ant building route
+	visited []bool
+	weights []float64
+	options []FlightIndex
+}
 
 func (e AntEngine) Name() string {
 	return fmt.Sprintf("%s(%d)", "AntEngine", e.seed)
 }
 
 func (e AntEngine) Solve(comm comm, p Problem) {
-	//defer profile.Start(/*profile.MemProfile*/).Stop()
-	//fmt.Fprintf(os.Stderr, "") // TODO anti error, remove
-    if p.n < 200 {
-        rand.Seed(int64(e.seed) + time.Now().UTC().UnixNano())
-        feromones = make([]float32, len(p.flights))
-        antInit(p.n/2, p.n)
-        antSolver(p, e.graph, comm)
-    }
-	//comm.done()
+	if e.graph.size < 2 {
+		return
+	}
+	newColony(e.graph, e.seed, e.params).solve(comm)
 }
 
-func antInit(ant_n, problem_n int) {
-	ANTS = ant_n
-	ants = make([]ant, ant_n, ant_n)
-	for ai := range ants {
-		ants[ai].visited = make([]City, 0, problem_n)
-		ants[ai].fis = make([]FlightIndex, 0, problem_n)
+func newColony(graph Graph, seed int64, params antParams) *colony {
+	c := &colony{
+		graph:     graph,
+		feromones: make([]float64, len(graph.flights)),
+		price:     make([]float64, len(graph.flights)),
+		bestCost:  Money(math.MaxInt32),
+		rand:      rand.New(rand.NewSource(seed)),
+		params:    params,
+		visited:   make([]bool, graph.size),
+	}
+	for fi, f := range graph.flights {
+		c.price[fi] = math.Pow(1/float64(f.Cost+1), params.priceC)
 	}
+	// until the first route is known the bounds are not either
+	c.max, c.min = 1, 1
+	c.reset()
+	return c
 }
 
-func antSolver(problem Problem, graph Graph, comm comm) {
-	//solution := make([]Flight, 0, graph.size)
-	var maxTotal Money
-	antsFinished := 0
-	for {
-		maxTotal = 0
-		for ai := range ants {
-			for {
-				//printInfo("The chosen one", ai, ants[ai])
-				//printInfo("Ant:", ai)
-				fi, r := antFlight(problem, graph, ants[ai].visited, ants[ai].day, ants[ai].city)
-				antSteps++
-				if !r {
-					//printInfo("ant to die", ai, ants[ai].visited, "day", ants[ai].day, "city", ants[ai].city)
-					die(ai)
-					continue
-				}
-				//printInfo("FI:", fi)
-				flight := problem.flights[fi]
-				ants[ai].total += flight.Cost
-				ants[ai].day++
-				ants[ai].city = flight.To
-				if ants[ai].city == 0 { // ant has completed the route
-					if ants[ai].total > maxTotal {
-						maxTotal = ants[ai].total
-					}
-					break
-				} else {
-					ants[ai].visited = append(ants[ai].visited, ants[ai].city)
-					ants[ai].fis = append(ants[ai].fis, fi)
-				}
+func (c *colony) solve(comm comm) {
+	ants := min(c.graph.size, antMaxAnts)
+	stagnation := 0
+	for iteration := 1; ; iteration++ {
+		var iterBest []Flight
+		iterCost := Money(math.MaxInt32)
+		for a := 0; a < ants; a++ {
+			route := c.walk(comm)
+			if route == nil {
+				continue
 			}
-		}
-		for ai := range ants { // ants finished
-			ants[ai].day = 0
-			evaporate(EVAPORATE_P)
-			// place the feromones
-			for _, fi := range ants[ai].fis {
-				feromones[fi] += float32(maxTotal) / float32(ants[ai].total)
+			if c.params.polish {
+				route = c.polish(comm, route)
+			}
+			if cost := Cost(route); cost < iterCost {
+				iterBest, iterCost = route, cost
 			}
-			ants[ai].total = 0
-			ants[ai].visited = ants[ai].visited[:0]
-			ants[ai].fis = ants[ai].fis[:0]
-			antsFinished++
 		}
-		if antsFinished > 100000/problem.n {
-			//printInfo("ants finished")
-			antsFinished = 0
-			//printInfo("Feromones:", feromones)
-			followAnts(problem, graph, comm)
-			//printInfo("antSteps:", antSteps)
+		stagnation++
+		if iterCost < c.bestCost {
+			c.improve(iterBest, iterCost)
+			comm.sendSolution(NewSolution(iterBest))
+			stagnation = 0
+		}
+		// routes of other engines are followed too
+		if elite := comm.elites(); len(elite) > 0 && elite[0].totalCost < c.bestCost {
+			c.improve(elite[0].flights, elite[0].totalCost)
+			stagnation = 0
+		}
+		if c.best == nil {
+			continue
+		}
+		if stagnation == antStagnation {
+			c.reset()
+			stagnation = 0
+			continue
+		}
+		if iterBest == nil || iteration%antGlobalEvery == 0 {
+			c.update(c.best, c.bestCost)
+		} else {
+			c.update(iterBest, iterCost)
 		}
 	}
 }
 
-func evaporate(x float32) {
-	mf := float32(0.0)
-	remain := 1.0 - x
-	for fi := range feromones {
-		feromones[fi] *= remain
-		if feromones[fi] > mf {
-			mf = feromones[fi]
-		}
+// improve remembers better route, bounds of feromones follow its cost
+func (c *colony) improve(route []Flight, cost Money) {
+	first := c.best == nil
+	c.best = make([]Flight, len(route))
+	copy(c.best, route)
+	c.bestCost = cost
+	c.max = 1 / (c.params.evaporate * float64(cost+1))
+	c.min = c.max / float64(2*c.graph.size)
+	if first {
+		c.reset()
 	}
-	//printInfo("Max feromone:", mf)
 }
 
-func followAnts(problem Problem, graph Graph, comm comm) {
-	solution := make([]Flight, 0, graph.size)
-	var price Money
-	var city City
-	for {
-		solution = solution[:0]
-		visited := make([]City, 0, MAX_CITIES)
-		city = City(0)
-		price = Money(0)
-		for d := 0; d < graph.size; d++ {
-			//printInfo("FA:")
-			fi, r := antFlight(problem, graph, visited, Day(d), city)
-			if !r {
-				return
-			}
-			price += problem.flights[fi].Cost
-			if price >= randomCurrentBest {
-				break
-			}
-			city = problem.flights[fi].To
-			visited = append(visited, city)
-			solution = append(solution, problem.flights[fi])
-		}
-		if len(solution) == graph.size && price < antCurrentBest {
-			antCurrentBest = price
-			comm.sendSolution(NewSolution(solution))
-			//printInfo("ant solution sent, price", price)
-			/*
-				printInfo("Stats:")
-				dg := make([]struct{maxF float32; flights,f25 int}, problem.n)
-				for _, dtfi := range graph.antsGraph {
-					for d, tfi := range dtfi {
-						for _, fi := range tfi {
-							if dg[int(d)].maxF < feromones[fi] {
-								dg[int(d)].maxF = feromones[fi]
-							}
-							dg[int(d)].flights += 1
-						}
-					}
-				}
-				for _, dtfi := range graph.antsGraph {
-					for d, tfi := range dtfi {
-						for _, fi := range tfi {
-							if dg[int(d)].maxF/4.0 < feromones[fi] {
-								dg[int(d)].f25 += 1
-							}
-						}
-					}
-				}
-				for d:=0; d<problem.n; d++ {
-					x := dg[int(d)]
-					printInfo("day", d, "max", x.maxF, "flights", x.flights, "flights>25%", x.f25)
-				}
-			*/
-			return
-		}
+// reset puts maximal feromones on all flights, so that ants explore again
+func (c *colony) reset() {
+	for fi := range c.feromones {
+		c.feromones[fi] = c.max
 	}
 }
 
-func die(ai int) {
-	//printInfo("ant", ai, "dying")
-	ants[ai].day = 0
-	ants[ai].city = 0
-	ants[ai].visited = ants[ai].visited[:0]
-	ants[ai].fis = ants[ai].fis[:0]
-	// keep current total cost for now; maybe add maximum flight cost or assign current worst running ant total
+// update evaporates feromones and lets route leave some on its flights
+func (c *colony) update(route []Flight, cost Money) {
+	remain := 1 - c.params.evaporate
+	for fi := range c.feromones {
+		c.feromones[fi] = math.Max(c.feromones[fi]*remain, c.min)
+	}
+	amount := 1 / float64(cost+1)
+	for _, f := range route {
+		if fi := c.graph.index(f.From, f.Day, f.To); fi >= 0 {
+			c.feromones[fi] = math.Min(c.feromones[fi]+amount, c.max)
+		}
+	}
 }
 
-// ants don't fly
-
-func antWeight(problem Problem, fi FlightIndex, flights int, avgCost float64, avgFeromones float32) float32 {
-	// feromones influence
-	price := problem.flights[fi].Cost
-	rel_price := avgCost / float64(price) // 1.0 for average, 2.0 for 2x better than average
-	//printInfo("xxx", avgFeromones, flights, feromones[fi], ANTS)
-	rel_feromones := 1.0
-	if avgFeromones > 0.0 {
-		rel_feromones = float64(avgFeromones) * (1.0 - FEROMONE_WEIGHT)
-		rel_feromones += float64(feromones[fi]/avgFeromones) * FEROMONE_WEIGHT
+// walk builds route of one ant, nil if it got stuck
+func (c *colony) walk(comm comm) []Flight {
+	g := c.graph
+	for i := range c.visited {
+		c.visited[i] = false
+	}
+	route := make([]Flight, 0, g.size)
+	city := g.source
+	for day := 0; day < g.size; day++ {
+		comm.yield()
+		fi, ok := c.flight(Day(day), city)
+		if !ok {
+			return nil
+		}
+		f := g.flights[fi]
+		route = append(route, f)
+		c.visited[f.To] = true
+		city = f.To
 	}
-	//fmt.Fprintf(os.Stderr, "rf avg %.2f cur %.2f res %.2f %v\n", avgFeromones, feromones[fi], rel_feromones, flights)
-	f := math.Pow(rel_feromones, FEROM_C)
-	// price influence
-	p := math.Pow(rel_price, PRICE_C)
-	var result float32 = float32(f * p)
-	//fmt.Fprintf(os.Stderr, "f/p: %.4f * %.2f = %.4f, (feromones %.2f/%.2f, cost %v, fi %v)\n", f, p, result, feromones[fi], rel_feromones, price, fi)
-	return result
+	return route
 }
 
-// choose the flight ant will take
-func antFlight(problem Problem, graph Graph, visited []City, day Day, city City) (FlightIndex, bool) {
-	// first, find all possible flights and construct random distribution
-	possible_flights := make([]FlightIndex, 0, MAX_CITIES)
-	var maxCost Money = 0 // needed to normalize costs
-	var sumCost Money = 0
-	var sumFeromones float32 = 0.0
-	//var mw float32 = 0.0
-	for _, fi := range graph.antsGraph[city][day] {
-		if contains(visited, problem.flights[fi].To) {
+// flight chooses where the ant flies on the day, cheapest flights to
+// unvisited cities are candidates
+func (c *colony) flight(day Day, city City) (FlightIndex, bool) {
+	g := c.graph
+	flights := g.fromDay(city, day)
+	if len(flights) == 0 {
+		return 0, false
+	}
+	first := g.fromDayIndex(city, day)
+	c.options = c.options[:0]
+	c.weights = c.weights[:0]
+	var sum float64
+	for i, f := range flights {
+		last := int(day) == g.size-1
+		if (f.To == g.source) != last || c.visited[f.To] {
 			continue
 		}
-		possible_flights = append(possible_flights, fi)
-		cost := problem.flights[fi].Cost
-		if cost > maxCost {
-			maxCost = cost
+		fi := first + FlightIndex(i)
+		w := math.Pow(c.feromones[fi], c.params.feromC) * c.price[fi]
+		sum += w
+		c.options = append(c.options, fi)
+		c.weights = append(c.weights, sum)
+		if len(c.options) == antCandidates {
+			break
 		}
-		sumCost += cost
-		sumFeromones += feromones[fi]
 	}
-	//printInfo("mw:", mw)
-	flightCnt := len(possible_flights)
-	var avgCost = float64(sumCost) / float64(flightCnt)
-	var avgFeromones = sumFeromones / float32(flightCnt)
-
-	// second, return that ant is stuck if no flight possible
-	if flightCnt == 0 {
+	if len(c.options) == 0 {
 		return 0, false
 	}
-
-	// third, compute weights, we do in in extra cycle beacause of normalization
-	var fsum float32 = 0.0
-	thres := make([]float32, 0, MAX_CITIES+1) // array of thresholds
-	thres = append(thres, 0.0)                // easier logic later if we always start with 0.0
-	for _, fi := range possible_flights {
-		// compute weight of the flight
-		// TODO scale according to average flight price
-		w := antWeight(problem, fi, flightCnt, avgCost, avgFeromones)
-		//if w > mw { mw = w }
-		fsum += w
-		thres = append(thres, fsum)
+	r := c.rand.Float64() * sum
+	for i, w := range c.weights {
+		if r < w {
+			return c.options[i], true
+		}
 	}
+	return c.options[len(c.options)-1], true
+}
 
-	// fourth, choose flight randomly based on the distribution
-	r := rand.Float32() * fsum
-	result := flightCnt - 1
-	for i, f := range thres {
-		if r < f {
-			result = i - 1
-			break
+// polish swaps cities of route as long as it gets cheaper
+func (c *colony) polish(comm comm, route []Flight) []Flight {
+	n := len(route)
+	for improved := true; improved; {
+		improved = false
+		for i := 1; i < n; i++ {
+			comm.yield()
+			for j := i + 2; j < n; j++ {
+				if swapped := swap(c.graph, route, i, j); swapped != nil {
+					route = swapped
+					improved = true
+				}
+			}
 		}
 	}
-	return possible_flights[result], true
+	return route
 }
@@ -0,0 +1,224 @@
+package fsp
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// width of the first pass, it doubles with every pass
+const beamInitialWidth = 16
+
+// widest beam tried, memory grows with it
+const beamMaxWidth = 1 << 16
+
+// no wider beam is tried after this long, every pass takes about as long as
+// all passes before it
+const beamTime = 20 * time.Second
+
+// Beam search, it extends partial routes day by day keeping only the best
+// width of them, ranked by cost plus estimate of the rest of the route;
+// routes in the same city having visited the same cities are the same
+// state, only the cheapest of them is kept
+type Beam struct {
+	graph Graph
+	// cheapest flight to the city on any day from any city
+	minIn []Money
+	// random keys of cities, xor of keys of visited cities and at key of
+	// current city identifies state
+	keys []uint64
+	at   []uint64
+}
+
+func NewBeam(graph Graph, stats FlightStatistics, seed int64) Beam {
+	n := graph.size
+	e := Beam{graph, make([]Money, n), make([]uint64, n), make([]uint64, n)}
+	for to := 0; to < n; to++ {
+		e.minIn[to] = Money(math.MaxInt32)
+		for from := range stats.ByDest {
+			s := stats.ByDest[from][to]
+			if s.FlightCount > 0 && s.BestPrice < e.minIn[to] {
+				e.minIn[to] = s.BestPrice
+			}
+		}
+		if e.minIn[to] == Money(math.MaxInt32) {
+			e.minIn[to] = 0
+		}
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for i := range e.keys {
+		e.keys[i] = uint64(rng.Int63())<<1 ^ uint64(rng.Int63())
+		e.at[i] = uint64(rng.Int63())<<1 ^ uint64(rng.Int63())
+	}
+	return e
+}
+
+func (e Beam) Name() string {
+	return "Beam"
+}
+
+// partial route, flights are found by following parents, beam search
+// keeps parents of the day before only
+type beamNode struct {
+	parent  *beamNode
+	flight  Flight
+	city    City
+	cost    Money
+	rest    Money // estimate of the rest of the route
+	visited []uint64
+	key     uint64 // of visited cities
+	index   int    // in beamHeap, then among nodes of the day
+}
+
+func (b *beamNode) has(c City) bool {
+	return b.visited[c/64]&(1<<(c%64)) != 0
+}
+
+type byEstimate []*beamNode
+
+func (b byEstimate) Len() int {
+	return len(b)
+}
+func (b byEstimate) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+func (b byEstimate) Less(i, j int) bool {
+	return b[i].cost+b[i].rest < b[j].cost+b[j].rest
+}
+
+// beamHeap keeps the best nodes of the next day, the worst one on top so
+// that it is the one replaced when a better node comes; nodes are found by
+// their state too
+type beamHeap struct {
+	nodes  []*beamNode
+	states map[uint64]*beamNode
+}
+
+func (h *beamHeap) Len() int {
+	return len(h.nodes)
+}
+func (h *beamHeap) Less(i, j int) bool {
+	return h.nodes[i].cost+h.nodes[i].rest > h.nodes[j].cost+h.nodes[j].rest
+}
+func (h *beamHeap) Swap(i, j int) {
+	h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i]
+	h.nodes[i].index = i
+	h.nodes[j].index = j
+}
+func (h *beamHeap) Push(x interface{}) {
+	b := x.(*beamNode)
+	b.index = len(h.nodes)
+	h.nodes = append(h.nodes, b)
+}
+func (h *beamHeap) Pop() interface{} {
+	b := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return b
+}
+
+func (e Beam) Solve(comm comm, p Problem) {
+	if e.graph.size < 2 {
+		return
+	}
+	best := Money(math.MaxInt32)
+	limit := comm.newLimit(beamTime)
+	for width := beamInitialWidth; width <= beamMaxWidth; width *= 2 {
+		if s, ok := e.pass(comm, width, best, limit); ok {
+			best = comm.sendSolution(s)
+		}
+		if limit.expired() {
+			printInfo("Beam out of time at width", width)
+			return
+		}
+	}
+	printInfo("Beam reached maximal width")
+}
+
+// pass of the search with beam of given width, routes that can't get
+// cheaper than best are dropped; every node expanded is a step of limit
+func (e Beam) pass(comm comm, width int, best Money, limit *limit) (Solution, bool) {
+	g := e.graph
+	n := g.size
+	var rest Money
+	for _, m := range e.minIn {
+		rest += m
+	}
+	start := &beamNode{city: g.source, rest: rest, visited: make([]uint64, (n+63)/64)}
+	beam := []*beamNode{start}
+	// nodes of past days are kept only as position of parent and flight,
+	// routes are followed back through them
+	history := make([][]beamStep, n)
+	for day := 0; day < n && len(beam) > 0; day++ {
+		next := &beamHeap{make([]*beamNode, 0, width), make(map[uint64]*beamNode)}
+		for _, b := range beam {
+			comm.yield()
+			flights := g.fromDay(b.city, Day(day))
+			limit.count(len(flights))
+			for _, f := range flights {
+				if (f.To == g.source) != (day == n-1) || b.has(f.To) {
+					continue
+				}
+				cost := b.cost + f.Cost
+				rest := b.rest - e.minIn[f.To]
+				if cost+rest >= best {
+					continue
+				}
+				key := b.key ^ e.keys[f.To]
+				state := key ^ e.at[f.To]
+				if same, ok := next.states[state]; ok {
+					if same.cost > cost {
+						same.parent, same.flight, same.cost = b, f, cost
+						heap.Fix(next, same.index)
+					}
+					continue
+				}
+				var visited []uint64
+				if next.Len() == width {
+					worst := next.nodes[0]
+					if worst.cost+worst.rest <= cost+rest {
+						continue
+					}
+					// nodes of the next day have no children yet, the worst
+					// one can give its place and memory away
+					heap.Pop(next)
+					delete(next.states, worst.key^e.at[worst.city])
+					visited = worst.visited
+				} else {
+					visited = make([]uint64, len(b.visited))
+				}
+				copy(visited, b.visited)
+				visited[f.To/64] |= 1 << (f.To % 64)
+				node := &beamNode{b, f, f.To, cost, rest, visited, key, 0}
+				next.states[state] = node
+				heap.Push(next, node)
+			}
+		}
+		for i, b := range beam {
+			b.index = i
+		}
+		beam = next.nodes
+		sort.Sort(byEstimate(beam))
+		history[day] = make([]beamStep, len(beam))
+		for i, b := range beam {
+			history[day][i] = beamStep{int32(b.parent.index), FlightIndex(g.index(b.flight.From, b.flight.Day, b.flight.To))}
+			b.parent = nil
+		}
+	}
+	if len(beam) == 0 {
+		return Solution{}, false
+	}
+	flights := make([]Flight, n)
+	for i, d := 0, n-1; d >= 0; d-- {
+		flights[d] = g.flights[history[d][i].flight]
+		i = int(history[d][i].parent)
+	}
+	return NewSolution(flights), true
+}
+
+// beamStep is node of past day of beam search
+type beamStep struct {
+	parent int32 // position in nodes of the day before
+	flight FlightIndex
+}
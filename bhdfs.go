@@ -6,18 +6,15 @@ import (
 	//"os"
 	"sort"
 	//"github.com/pkg/profile"
-	"sync"
 )
 
 // Reverse node heuristics and DFS
 type Bhdfs struct {
 	graph Graph
 	skip  int
+	best  *bound
 }
 
-var BhdfsResultsCounter uint32
-var bhdfsCurrentBest = Money(math.MaxInt32)
-
 func (e Bhdfs) Name() string {
 	return fmt.Sprintf("%s(%d)", "Bhdfs", e.skip)
 }
@@ -27,43 +24,43 @@ func (e Bhdfs) Solve(comm comm, p Problem) {
 	if e.graph.size > 200 {
 		return
 	}
-	bhdfsSolver(e.graph, p.stats, comm, e.skip)
+	bhdfsSolver(e.graph, p.stats, e.best, comm, e.skip)
 	//comm.done()
 }
 
-func bhdfsSolver(graph Graph, stats FlightStatistics, comm comm, skip int) /*[]Flight*/ {
+func bhdfsSolver(graph Graph, stats FlightStatistics, best *bound, comm comm, skip int) /*[]Flight*/ {
 
 	printInfo("starting bhdfs solver", skip)
-	visited := make([]City, 0, MAX_CITIES)
+	visited := make([]City, 0, graph.size)
 	solution := make([]Flight, 0, graph.size)
 	home := City(0)
 	day := Day(0)
 	price := Money(0)
-	var once sync.Once
-	once.Do(func() {
-		bhdfsEvaluate(graph)
-		printInfo("bhdfs evaluation completed")
-	})
-	bhdfsIterate(solution, day, home, visited, graph, stats, price, comm, skip)
+	// graph is shared with other engines, heuristics are ours only
+	h := bhdfsEvaluate(graph)
+	printInfo("bhdfs evaluation completed")
+	bhdfsIterate(solution, day, home, visited, graph, h, stats, best, price, comm, skip)
 }
 
-func bhdfsEvaluate(g Graph) {
+// bhdfsEvaluate returns heuristic of every flight indexed by FlightIndex
+func bhdfsEvaluate(g Graph) []Money {
+	h := make([]Money, len(g.flights))
 	// evaluate each node in graph with best/worst price to reach final destination
 	for day := g.size - 1; day >= 0; day-- {
-		for i, flights := range g.dayFromData[day] {
+		for i := 0; i < g.size; i++ {
+			first := g.fromDayIndex(City(i), Day(day))
+			flights := g.fromDay(City(i), Day(day))
 			if day == g.size-1 {
-				for j, f /*lights2*/ := range flights {
-					//for _, f := range flights2 {
-					g.dayFromData[day][i][j].Heuristic = f.Cost
-					//}
+				for j, f := range flights {
+					h[int(first)+j] = f.Cost
 				}
 				continue
 			}
-			//for _, flights2 := range flights{
-			for j, f := range flights { //flights on day day, from j
+			for j, f := range flights { //flights on day day, from i
 				best := Money(math.MaxInt32)
 				worst := Money(0)
-				for _, f2 := range g.dayFromData[day+1][f.To] {
+				next := g.fromDayIndex(f.To, Day(day+1))
+				for k, f2 := range g.fromDay(f.To, Day(day+1)) {
 					if f2.To == f.From {
 						// avoid short cycles (how to avoid long ones?)
 						// printInfo("short cycle--", f, f2)
@@ -71,32 +68,22 @@ func bhdfsEvaluate(g Graph) {
 					}
 
 					if f2.Cost < best {
-						best = f2.Heuristic
+						best = h[int(next)+k]
 					}
 					if f2.Cost > worst {
-						worst = f2.Heuristic
+						worst = h[int(next)+k]
 					}
 
 					//printInfo("candidate on", day, f, f2)
 				}
 				//f.Heuristic = best
-				g.dayFromData[day][i][j].Heuristic = (worst+best)/2 + f.Cost
+				h[int(first)+j] = (worst+best)/2 + f.Cost
 				//printInfo("day", day, "from", i, "worst", worst, f)
 			}
-			//}
-			//printInfo(flights)
 		}
 		//printInfo("Day:", day, )
 	}
-	/*
-		for i, x := range g.dayFromData {
-			for j, y := range x {
-				for _, z := range y {
-					printInfo("[", i, j, "->", z.To, "]:", *z)
-					}
-				}
-			}
-	*/
+	return h
 }
 
 func bhdfsInsertSortedFlight(slice []EvaluatedFlight, node EvaluatedFlight) []EvaluatedFlight {
@@ -117,26 +104,23 @@ func bhdfsInsertSortedFlight(slice []EvaluatedFlight, node EvaluatedFlight) []Ev
 }
 
 func bhdfsIterate(partial []Flight, day Day, current City,
-	visited []City, graph Graph, stats FlightStatistics, price Money, comm comm, skip int) {
+	visited []City, graph Graph, h []Money, stats FlightStatistics, best *bound, price Money, comm comm, skip int) {
 
-	if price >= bhdfsCurrentBest {
+	comm.yield()
+	if price >= best.get() {
 		// we have already got worse than best result, give it up, bro
-		BhdfsResultsCounter++
 		return
 	}
 	if int(day) == graph.size {
-		BhdfsResultsCounter++
-		//if price < bhdfsCurrentBest {
-		//bhdfsCurrentBest = price
-		bhdfsCurrentBest = comm.sendSolution(NewSolution(partial))
-		//}
+		best.set(comm.sendSolution(NewSolution(partial)))
 		return
 	}
 	//fmt.Fprintln(os.Stderr, "I am at", current, "day is", day)
 	var current_deal float32
 	//var current_deal int32
-	possible_flights := make([]EvaluatedFlight, 0, MAX_CITIES)
-	for _, f := range graph.fromDaySortedCost[current][day] {
+	possible_flights := make([]EvaluatedFlight, 0, graph.size)
+	first := graph.fromDayIndex(current, day)
+	for j, f := range graph.fromDay(current, day) {
 		if contains(visited, f.To) {
 			continue
 		}
@@ -148,11 +132,11 @@ func bhdfsIterate(partial []Flight, day Day, current City,
 			// no discount, no deal, bro
 			continue
 		}
-		current_deal = float32(f.Cost+f.Heuristic/2) - 0.6*discount
+		current_deal = float32(f.Cost+h[int(first)+j]/2) - 0.6*discount
 		//printInfo(f)
 
-		//possible_flights = append(possible_flights, EvaluatedFlight{*f, current_deal})
-		possible_flights = bhdfsInsertSortedFlight(possible_flights, EvaluatedFlight{*f, current_deal})
+		//possible_flights = append(possible_flights, EvaluatedFlight{f, current_deal})
+		possible_flights = bhdfsInsertSortedFlight(possible_flights, EvaluatedFlight{f, current_deal})
 	}
 	//sort.Sort(byValue(possible_flights))
 	for i, f := range possible_flights {
@@ -165,7 +149,7 @@ func bhdfsIterate(partial []Flight, day Day, current City,
 			f.flight.To,
 			append(visited, f.flight.To),
 			//bhdfsInsertVisited(visited, f.flight.To),
-			graph, stats,
+			graph, h, stats, best,
 			price+f.flight.Cost,
 			comm, skip)
 	}
@@ -0,0 +1,241 @@
+package fsp
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// prefixes and suffixes kept on each day in the first pass, it doubles
+// with every pass
+const bidirInitialWidth = 64
+
+// widest pass tried, memory grows with it
+const bidirMaxWidth = 1 << 14
+
+// prefixes getting suffixes of their own in every pass
+const bidirGuided = 4
+
+// Bidirectional search, it extends routes forward from the start up to the
+// middle day and backward from the end down to it, keeping the cheapest
+// routes of every city they end in so that the halves stay diverse; prefix
+// and suffix meeting in the same city join into a route when suffix visits
+// exactly cities prefix does not, xor of city keys finds such pairs
+type Bidir struct {
+	graph Graph
+	// random keys of cities, xor of keys of visited cities and at key of
+	// current city identifies state, like in Beam
+	keys []uint64
+	at   []uint64
+	// xor of keys of all cities except source
+	all uint64
+}
+
+func NewBidir(graph Graph, seed int64) Bidir {
+	n := graph.size
+	e := Bidir{graph: graph, keys: make([]uint64, n), at: make([]uint64, n)}
+	rng := rand.New(rand.NewSource(seed))
+	for i := range e.keys {
+		e.keys[i] = uint64(rng.Int63())<<1 ^ uint64(rng.Int63())
+		e.at[i] = uint64(rng.Int63())<<1 ^ uint64(rng.Int63())
+		if City(i) != graph.source {
+			e.all ^= e.keys[i]
+		}
+	}
+	return e
+}
+
+func (e Bidir) Name() string {
+	return "Bidir"
+}
+
+func (e Bidir) Solve(comm comm, p Problem) {
+	if e.graph.size < 2 {
+		return
+	}
+	best := Money(math.MaxInt32)
+	for width := bidirInitialWidth; width <= bidirMaxWidth; width *= 2 {
+		if s, ok := e.pass(comm, width, best); ok {
+			best = comm.sendSolution(s)
+		}
+	}
+	printInfo("Bidir reached maximal width")
+}
+
+// pass builds both halves with given width and joins them, the cheapest
+// route cheaper than best is returned
+func (e Bidir) pass(comm comm, width int, best Money) (Solution, bool) {
+	g := e.graph
+	n := g.size
+	middle := n / 2
+	prefixes := e.forward(comm, middle, width, best)
+	suffixes := e.backward(comm, middle, width, best, nil)
+
+	// suffix both visiting and ending in the city, prefix visits it too so
+	// the key of the city drops out of the join
+	joins := make(map[uint64]*beamNode, len(suffixes))
+	for _, s := range suffixes {
+		joins[s.key^e.at[s.city]] = s
+	}
+	var bp, bs *beamNode
+	for _, pr := range prefixes {
+		comm.yield()
+		s, ok := joins[e.all^e.keys[pr.city]^pr.key^e.at[pr.city]]
+		if !ok || pr.cost+s.cost >= best || !e.disjoint(pr, s) {
+			continue
+		}
+		best = pr.cost + s.cost
+		bp, bs = pr, s
+	}
+
+	// independent halves rarely fit together on big instances, the
+	// cheapest prefixes get suffixes built only of cities they miss,
+	// diverse puts the cheapest ones of different cities first
+	for i := 0; i < bidirGuided && i < len(prefixes); i++ {
+		pr := prefixes[i]
+		for _, s := range e.backward(comm, middle, width, best-pr.cost, pr) {
+			if pr.cost+s.cost < best {
+				best = pr.cost + s.cost
+				bp, bs = pr, s
+			}
+		}
+	}
+	if bp == nil {
+		return Solution{}, false
+	}
+	flights := make([]Flight, n)
+	for b, d := bp, middle-1; d >= 0; b, d = b.parent, d-1 {
+		flights[d] = b.flight
+	}
+	for b, d := bs, middle; d < n; b, d = b.parent, d+1 {
+		flights[d] = b.flight
+	}
+	return NewSolution(flights), true
+}
+
+// disjoint checks the cities of halves do not overlap except the one they
+// meet in, keys only tell it with high probability
+func (e Bidir) disjoint(pr, s *beamNode) bool {
+	for i := range pr.visited {
+		common := pr.visited[i] & s.visited[i]
+		if int(pr.city)/64 == i {
+			common &^= 1 << (pr.city % 64)
+		}
+		if common != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// forward extends routes from the source by flights of days before middle,
+// routes are in the city they arrived to
+func (e Bidir) forward(comm comm, middle, width int, best Money) []*beamNode {
+	g := e.graph
+	start := &beamNode{city: g.source, visited: make([]uint64, (g.size+63)/64)}
+	layer := []*beamNode{start}
+	for day := 0; day < middle && len(layer) > 0; day++ {
+		var next []*beamNode
+		states := make(map[uint64]int)
+		for _, b := range layer {
+			comm.yield()
+			for _, f := range g.fromDay(b.city, Day(day)) {
+				if f.To == g.source || b.has(f.To) {
+					continue
+				}
+				next = e.extend(next, states, b, f, f.To, best)
+			}
+		}
+		layer = diverse(next, width)
+	}
+	return layer
+}
+
+// backward extends routes from the source on the last day back by flights
+// of days from middle on, routes are in the city their first flight
+// departs from; given prefix they avoid its cities and start where it ends
+func (e Bidir) backward(comm comm, middle, width int, best Money, prefix *beamNode) []*beamNode {
+	g := e.graph
+	start := &beamNode{city: g.source, visited: make([]uint64, (g.size+63)/64)}
+	layer := []*beamNode{start}
+	for day := g.size - 1; day >= middle && len(layer) > 0; day-- {
+		var next []*beamNode
+		states := make(map[uint64]int)
+		for _, b := range layer {
+			comm.yield()
+			// only the last flight arrives to the source
+			if (b.city == g.source) != (day == g.size-1) {
+				continue
+			}
+			for _, i := range g.toDay(b.city, Day(day)) {
+				f := g.flights[i]
+				if f.From == g.source || b.has(f.From) {
+					continue
+				}
+				if prefix != nil && (prefix.has(f.From) != (day == middle) || day == middle && f.From != prefix.city) {
+					continue
+				}
+				next = e.extend(next, states, b, f, f.From, best)
+			}
+		}
+		layer = diverse(next, width)
+	}
+	return layer
+}
+
+// extend adds route b continued by flight f to city c to next unless the
+// same state is there already cheaper
+func (e Bidir) extend(next []*beamNode, states map[uint64]int, b *beamNode, f Flight, c City, best Money) []*beamNode {
+	cost := b.cost + f.Cost
+	if cost >= best {
+		return next
+	}
+	key := b.key ^ e.keys[c]
+	if i, ok := states[key^e.at[c]]; ok {
+		if next[i].cost > cost {
+			next[i].parent, next[i].flight, next[i].cost = b, f, cost
+		}
+		return next
+	}
+	visited := make([]uint64, len(b.visited))
+	copy(visited, b.visited)
+	visited[c/64] |= 1 << (c % 64)
+	states[key^e.at[c]] = len(next)
+	return append(next, &beamNode{b, f, c, cost, 0, visited, key, 0})
+}
+
+type byCostNode []*beamNode
+
+func (b byCostNode) Len() int {
+	return len(b)
+}
+func (b byCostNode) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+func (b byCostNode) Less(i, j int) bool {
+	return b[i].cost < b[j].cost
+}
+
+// diverse keeps width cheapest routes taking them from cities in turns, so
+// that every city they can end in keeps some of them
+func diverse(nodes []*beamNode, width int) []*beamNode {
+	width = min(width, len(nodes))
+	sort.Stable(byCostNode(nodes))
+	byCity := make(map[City][]*beamNode)
+	var cities []City
+	for _, b := range nodes {
+		if len(byCity[b.city]) == 0 {
+			cities = append(cities, b.city)
+		}
+		byCity[b.city] = append(byCity[b.city], b)
+	}
+	kept := make([]*beamNode, 0, width)
+	for i := 0; len(kept) < width; i++ {
+		for _, c := range cities {
+			if i < len(byCity[c]) && len(kept) < width {
+				kept = append(kept, byCity[c][i])
+			}
+		}
+	}
+	return kept
+}
@@ -3,18 +3,22 @@ package fsp
 import (
 	"math"
 	"sort"
-	"time"
 )
 
+// Bottleneck searches from flights of cities with few of them, which every
+// route has to take one of, in turns, each for a run of Luby restart
+// schedule
 type Bottleneck struct {
 	graph       Graph
 	currentBest Money
+	seed        int64
 }
 
-func NewBottleneck(g Graph) Bottleneck {
+func NewBottleneck(g Graph, seed int64) Bottleneck {
 	return Bottleneck{
-		graph,
+		g,
 		Money(math.MaxInt32),
+		seed,
 	}
 
 }
@@ -40,18 +44,28 @@ func (d Bottleneck) Solve(comm comm, problem Problem) {
 	visited := make(map[City]bool)
 	partial := partial{visited, flights, problem.n, 0}
 	btn := d.findBottlenecks(problem)
-	t := 30000.0 / float32(len(btn))
 	printInfo("Found", len(btn), "bottlenecks")
+	var starts []Flight
 	for _, b := range btn {
-		timePerBtn := t / float32(min(len(btn), 3))
-		printInfo("Testing", min(len(btn), 3), "flights in bottleneck")
 		sort.Sort(byCost2(b))
-		for _, f := range b {
-			partial.fly(&f)
-			tb := time.Duration(timePerBtn) * time.Millisecond
-			printInfo("running dfs from bottleneck for", tb)
-			d.dfs(comm, &partial, time.After(tb))
-			partial.backtrack()
+		starts = append(starts, b...)
+	}
+	done := make([]bool, len(starts))
+	left := len(starts)
+	r := newRestarts(lubySchedule(restartUnit), d.seed, newNogoods(problem.n, d.seed))
+	for run := 0; left > 0; run++ {
+		i := run % len(starts)
+		if done[i] {
+			continue
+		}
+		// every start gets its first run in original order
+		r.begin(run / len(starts))
+		partial.fly(&starts[i])
+		cut := d.dfs(comm, &partial, r, r.start(starts[i].From))
+		partial.backtrack()
+		if !cut {
+			done[i] = true
+			left--
 		}
 	}
 }
@@ -124,6 +138,12 @@ func (b btnStat) get() [][]Flight {
 }
 
 func (b *Bottleneck) findBottlenecks(p Problem) [][]Flight {
+	return collectBottlenecks(p).get()
+}
+
+// flights from and to each city, cities with too many flights are not
+// bottlenecks and have nil lists
+func collectBottlenecks(p Problem) btnStat {
 	bs := initB(p.n)
 	for _, f := range p.flights {
 		if f.From == 0 || f.To == 0 {
@@ -131,11 +151,13 @@ func (b *Bottleneck) findBottlenecks(p Problem) [][]Flight {
 		}
 		bs.add(f)
 	}
-	return bs.get()
+	return bs
 }
 
-func (b *Bottleneck) dfs(comm comm, partial *partial, timeout <-chan time.Time) bool {
-	if expired(timeout) {
+// dfs returns true when restart cut the search
+func (b *Bottleneck) dfs(comm comm, partial *partial, r *restarts, key nogoodKey) bool {
+	comm.yield()
+	if r.step() {
 		return true
 	}
 	if partial.cost > b.currentBest {
@@ -150,14 +172,20 @@ func (b *Bottleneck) dfs(comm comm, partial *partial, timeout <-chan time.Time)
 		return false
 	}
 
-	dst := b.graph.fromDaySortedCost[lf.To][int(lf.Day+1)%b.graph.size]
-	for _, f := range dst {
-		partial.fly(f)
-		expired := b.dfs(comm, partial, timeout)
-		if expired {
+	day := Day(int(lf.Day+1) % b.graph.size)
+	state := r.state(key, lf.To, day)
+	if r.failed(state, partial.cost) {
+		return false
+	}
+	dst := b.graph.fromDay(lf.To, day)
+	for _, i := range r.flightOrder(dst) {
+		partial.fly(&dst[i])
+		cut := b.dfs(comm, partial, r, r.visit(key, lf.To))
+		partial.backtrack()
+		if cut {
 			return true
 		}
-		partial.backtrack()
 	}
+	r.fail(state, partial.cost)
 	return false
 }
@@ -0,0 +1,117 @@
+package fsp
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// how often budgets are checked
+const budgetTick = 10 * time.Millisecond
+
+// Report describes how the problem was solved and why solving stopped
+type Report struct {
+	Seed         int64
+	Pruned       float64 // share of flights left out of the graph
+	Nodes        uint64  // search nodes/iterations explored by all engines
+	Solutions    uint64  // full solutions evaluated by all engines
+	Improvements int
+	Elapsed      time.Duration
+	FirstFound   time.Duration // time to first solution
+	BestFound    time.Duration // time to final best solution
+	Stop         string
+	Engines      []EngineReport
+}
+
+// EngineReport is effort spent by one engine
+type EngineReport struct {
+	Name      string
+	Nodes     uint64
+	Solutions uint64
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("stopped by %s after %v, %.1f%% flights pruned, %d nodes, %d solutions, %d improvements, first after %v, best after %v",
+		r.Stop, r.Elapsed, r.Pruned*100, r.Nodes, r.Solutions, r.Improvements, r.FirstFound, r.BestFound)
+}
+
+// reasons to stop
+const (
+	stopTimeout    = "timeout"
+	stopCancelled  = "cancel"
+	stopFinished   = "search finished"
+	stopRounds     = "rounds"
+	stopNodes      = "node budget"
+	stopSolutions  = "solution budget"
+	stopStallTime  = "no improvement time"
+	stopStallNodes = "no improvement nodes"
+	stopInfeasible = "infeasible"
+)
+
+// budget keeps track of effort spent by engines, it is only used by the
+// coordinator, engines count their effort in their gates
+type budget struct {
+	o         Options
+	engines   []Engine
+	gates     []*gate
+	start     time.Time
+	improved  time.Time
+	nodesThen uint64 // nodes explored at the time of last improvement
+	report    Report
+}
+
+func newBudget(o Options, engines []Engine, gates []*gate) *budget {
+	now := time.Now()
+	return &budget{o: o, engines: engines, gates: gates, start: now, improved: now, report: Report{Seed: o.Seed}}
+}
+
+// any budget other than wall time set
+func (o Options) budgeted() bool {
+	return o.MaxNodes > 0 || o.MaxSolutions > 0 || o.StallTime > 0 || o.StallNodes > 0
+}
+
+func (b *budget) count() (nodes, solutions uint64) {
+	for _, g := range b.gates {
+		nodes += atomic.LoadUint64(&g.nodes)
+		solutions += atomic.LoadUint64(&g.solutions)
+	}
+	return
+}
+
+func (b *budget) improvement() {
+	b.improved = time.Now()
+	b.nodesThen, _ = b.count()
+	if b.report.Improvements == 0 {
+		b.report.FirstFound = time.Since(b.start)
+	}
+	b.report.Improvements++
+	b.report.BestFound = time.Since(b.start)
+}
+
+// returns reason to stop, empty string if we can go on
+func (b *budget) exhausted() string {
+	nodes, solutions := b.count()
+	switch {
+	case b.o.MaxNodes > 0 && nodes >= b.o.MaxNodes:
+		return stopNodes
+	case b.o.MaxSolutions > 0 && solutions >= b.o.MaxSolutions:
+		return stopSolutions
+	case b.o.StallTime > 0 && time.Since(b.improved) >= b.o.StallTime:
+		return stopStallTime
+	case b.o.StallNodes > 0 && nodes-b.nodesThen >= b.o.StallNodes:
+		return stopStallNodes
+	}
+	return ""
+}
+
+func (b *budget) stop(reason string) Report {
+	b.report.Nodes, b.report.Solutions = b.count()
+	for i, g := range b.gates {
+		b.report.Engines = append(b.report.Engines, EngineReport{b.engines[i].Name(),
+			atomic.LoadUint64(&g.nodes), atomic.LoadUint64(&g.solutions)})
+	}
+	b.report.Elapsed = time.Since(b.start)
+	b.report.Stop = reason
+	printInfo("Stopped:", b.report)
+	return b.report
+}
@@ -0,0 +1,118 @@
+package fsp
+
+import (
+	"math"
+	"math/rand"
+)
+
+// maximal number of dfs leaves when restarting Dcfs from a prefix
+const crossoverRestartLeaves = 1 << 15
+
+// Engine combining solutions found by other engines, it either restarts
+// Dcfs from a prefix of an elite solution, or recombines two elite
+// solutions keeping prefix of the first one and order of the second one
+type Crossover struct {
+	graph Graph
+	stats FlightStatistics
+	dcfs  *dcfsState
+	rng   *rand.Rand
+}
+
+func NewCrossover(graph Graph, stats FlightStatistics, dcfs *dcfsState, seed int64) Crossover {
+	return Crossover{graph, stats, dcfs, rand.New(rand.NewSource(seed))}
+}
+
+func (e Crossover) Name() string {
+	return "Crossover"
+}
+
+func (e Crossover) Solve(comm comm, p Problem) {
+	n := e.graph.size
+	if n < 4 {
+		return
+	}
+	for {
+		comm.yield()
+		elite := comm.elites()
+		if len(elite) == 0 {
+			// wait for other engines to fill the pool
+			comm.idle()
+			continue
+		}
+		if len(elite) == 1 || e.rng.Intn(2) == 0 {
+			e.restart(comm, elite[e.rng.Intn(len(elite))])
+			continue
+		}
+		a := e.rng.Intn(len(elite))
+		b := e.rng.Intn(len(elite) - 1)
+		if b >= a {
+			b++
+		}
+		cut := e.rng.Intn(n-2) + 1
+		if child, ok := recombine(e.graph, elite[a], elite[b], cut); ok {
+			comm.sendSolution(child)
+		}
+	}
+}
+
+// run Dcfs on a short suffix of the solution
+func (e Crossover) restart(comm comm, s Solution) {
+	n := len(s.flights)
+	branches := e.dcfs.maxBranches
+	if branches < 2 {
+		branches = 2
+	}
+	maxSuffix := int(math.Log2(crossoverRestartLeaves) / math.Log2(float64(branches)))
+	maxSuffix = min(maxSuffix, n-1)
+	if maxSuffix < 2 {
+		maxSuffix = 2
+	}
+	suffix := e.rng.Intn(maxSuffix-1) + 2
+	dcfsRestart(e.graph, e.stats, e.dcfs, comm, s.flights[:n-suffix])
+}
+
+// child keeps first cut flights of a, remaining cities are visited in the
+// order they appear in b, if it is possible, otherwise cheapest flight
+// is taken
+func recombine(g Graph, a, b Solution, cut int) (Solution, bool) {
+	n := g.size
+	visited := make([]bool, n)
+	flights := make([]Flight, 0, n)
+	for _, f := range a.flights[:cut] {
+		flights = append(flights, f)
+		visited[f.To] = true
+	}
+	current := flights[cut-1].To
+	for day := Day(cut); int(day) < n-1; day++ {
+		var next *Flight
+		for _, f := range b.flights {
+			if visited[f.To] || f.To == 0 {
+				continue
+			}
+			if next = g.get(current, day, f.To); next != nil {
+				break
+			}
+		}
+		if next == nil {
+			fl := g.fromDay(current, day)
+			for i := range fl {
+				if !visited[fl[i].To] && fl[i].To != 0 {
+					next = &fl[i]
+					break
+				}
+			}
+		}
+		if next == nil {
+			return Solution{}, false
+		}
+		flights = append(flights, *next)
+		visited[next.To] = true
+		current = next.To
+	}
+	home := g.get(current, Day(n-1), 0)
+	if home == nil {
+		return Solution{}, false
+	}
+	flights = append(flights, *home)
+	return NewSolution(flights), true
+}
@@ -1,78 +1,86 @@
 package fsp
 
 import (
-	"fmt"
-	"math"
-	"os"
+	"math/rand"
+	"runtime"
 	"sort"
-	"strconv"
+	"sync"
 	//"github.com/pkg/profile"
 )
 
 // Depth + Cheapest First Search engine
 // a variant of greedy DFS using cheapest next flight first with heuristics based on average price for same flights on different days
+// the tree is split into subtrees searched by workers, worker done with its
+// own subtrees steals them from the others
+// subtrees not searched within budget of restart schedule go back to the
+// queue and are searched again later with bigger one
 type Dcfs struct {
-	graph Graph
-	skip  int
+	graph   Graph
+	workers int
+	seed    int64
+	state   *dcfsState
 }
 
-var DcfsResultsCounter uint32
-var DcfsBranchCounter []uint32
-var dcfsCurrentBest = Money(math.MaxInt32)
+// most workers searching the tree at the same time
+const dcfsMaxWorkers = 4
 
-// engine parms
-var pMaxBranches = MAX_CITIES
-var pDiscountWeight = float32(0.6)
-var pNextAvgWeight = float32(-0.2)
-var pMinDiscount = float32(-0.5)
-var pDiscountThreshold = Money(0) // set as avg flight price in Solve()
-
-func (e Dcfs) Name() string {
-	return fmt.Sprintf("%s(%d)", "Dcfs", e.skip)
+// state shared by all Dcfs instances and restarts of one run
+type dcfsState struct {
+	best *bound
+	// engine parms
+	maxBranches       int
+	discountWeight    float32
+	nextAvgWeight     float32
+	minDiscount       float32
+	discountThreshold Money
+	// depth of roots of subtrees given to workers
+	splitDepth int
+	nogoods    *nogoods
+	// probabilities of flights by index given by learned model, nil
+	// without one
+	learned []float64
 }
 
-func dcfsLoadEnvParams() {
-	var env string
-	env = os.Getenv("DCFS_MAX_BRANCHES")
-	if len(env) > 0 {
-		pMaxBranches, _ = strconv.Atoi(env)
-	}
-	env = os.Getenv("DCFS_DISC_W")
-	if len(env) > 0 {
-		tmp, _ := strconv.ParseFloat(env, 32)
-		pDiscountWeight = float32(tmp)
+// learned probabilities are scaled to be comparable with prices in deals
+const dcfsLearnedScale = 100
+
+func newDcfsState(p Problem, ps params) *dcfsState {
+	maxBranches := p.n / 2
+	if p.n > 20 {
+		maxBranches = 2
 	}
-	env = os.Getenv("DCFS_NEXT_AVG_W")
-	if len(env) > 0 {
-		tmp, _ := strconv.ParseFloat(env, 32)
-		pNextAvgWeight = float32(tmp)
+	return &dcfsState{
+		best:              newBound(),
+		maxBranches:       int(ps.get("DCFS_MAX_BRANCHES", float64(maxBranches))),
+		discountWeight:    float32(ps.get("DCFS_DISC_W", 0.6)),
+		nextAvgWeight:     float32(ps.get("DCFS_NEXT_AVG_W", -0.2)),
+		minDiscount:       float32(ps.get("DCFS_MIN_DISC", -0.5)),
+		discountThreshold: Money(ps.get("DCFS_DISC_THRESH", float64(p.FlightStats().AvgPrice))),
+		splitDepth:        int(ps.get("DCFS_SPLIT_DEPTH", 2)),
+		nogoods:           newNogoods(p.n, 1),
 	}
-	env = os.Getenv("DCFS_MIN_DISC")
-	if len(env) > 0 {
-		tmp, _ := strconv.ParseFloat(env, 32)
-		pMinDiscount = float32(tmp)
+}
+
+// number of Dcfs workers, at least two so that the search does not stick
+// to the first subtree; deterministic run searches the tree alone in order
+func dcfsWorkers(deterministic bool) int {
+	if deterministic {
+		return 1
 	}
-	env = os.Getenv("DCFS_DISC_THRESH")
-	if len(env) > 0 {
-		tmp, _ := strconv.Atoi(env)
-		pDiscountThreshold = Money(tmp)
+	workers := min(runtime.NumCPU(), dcfsMaxWorkers)
+	if workers < 2 {
+		workers = 2
 	}
+	return workers
+}
+
+func (e Dcfs) Name() string {
+	return "Dcfs"
 }
 
 func (e Dcfs) Solve(comm comm, p Problem) {
 	//defer profile.Start(/*profile.MemProfile*/).Stop()
-	DcfsBranchCounter = make([]uint32, e.graph.size+1)
-	pDiscountThreshold = Money(p.FlightStats().AvgPrice)
-	if p.n > 20 {
-		pMaxBranches = 2
-	} else {
-		pMaxBranches = p.n / 2
-	}
-	if e.skip > 0 && p.n < 20 {
-		return
-	}
-	dcfsLoadEnvParams()
-	dcfsSolver(e.graph, p.stats, comm, e.skip)
+	dcfsSolver(e.graph, p.stats, e.state, comm, e.workers, e.seed)
 	//comm.done()
 }
 
@@ -140,44 +148,286 @@ func dcfsVisited(slice []City, c City) bool {
 	return false
 }
 
-func dcfsSolver(graph Graph, stats FlightStatistics, comm comm, skip int) /*[]Flight*/ {
+func dcfsSolver(graph Graph, stats FlightStatistics, state *dcfsState, comm comm, workers int, seed int64) /*[]Flight*/ {
+
+	printInfo("starting dcfs solver with", workers, "workers")
+	root := dcfsTask{partial: make([]Flight, 0, graph.size), visited: make([]City, 0, graph.size)}
+	var tasks []dcfsTask
+	dcfsSplit(root, state.splitDepth, graph, stats, state, &tasks)
+
+	// subtrees in search order are dealt to workers in turns, so that each
+	// of them starts with a promising one
+	queues := make([]*dcfsQueue, workers)
+	for w := range queues {
+		queues[w] = &dcfsQueue{}
+	}
+	for i, t := range tasks {
+		q := queues[i%workers]
+		q.tasks = append(q.tasks, t)
+	}
+	var wg sync.WaitGroup
+	work := newDcfsWork(len(tasks))
+	rng := rand.New(rand.NewSource(seed))
+	for w := range queues {
+		wg.Add(1)
+		r := newRestarts(geometricSchedule(restartUnit, restartGrowth), rng.Int63(), state.nogoods)
+		go func(w int) {
+			defer wg.Done()
+			// worker stopped in the middle of subtree does not finish it,
+			// the others must not wait for it
+			running := false
+			defer func() {
+				if running {
+					work.stop()
+				}
+			}()
+			for {
+				generation := work.generation()
+				t, ok := queues[w].pop()
+				for v := 1; !ok && v < workers; v++ {
+					t, ok = queues[(w+v)%workers].steal()
+				}
+				if !ok {
+					// subtrees in flight may still come back to queues
+					if work.wait(generation) {
+						continue
+					}
+					return
+				}
+				last := len(t.partial) - 1
+				day, city := Day(0), graph.source
+				if last >= 0 {
+					day, city = t.partial[last].Day+1, t.partial[last].To
+				}
+				r.begin(t.run)
+				running = true
+				cut, _ := dcfsIterate(t.partial, day, city, t.visited, graph, stats, state, t.price, comm, r, t.key)
+				running = false
+				if cut {
+					t.run++
+					queues[w].push(t)
+					work.pushed()
+				} else {
+					work.finished()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// dcfsWork counts subtrees either queued or being searched, idle workers
+// wait for them until there are none
+type dcfsWork struct {
+	m       sync.Mutex
+	c       *sync.Cond
+	pending int
+	// changes whenever a subtree is pushed or finished
+	gen     int
+	stopped bool
+}
+
+func newDcfsWork(tasks int) *dcfsWork {
+	w := &dcfsWork{pending: tasks}
+	w.c = sync.NewCond(&w.m)
+	return w
+}
 
-	printInfo("starting dcfs solver", skip)
-	visited := make([]City, 0, MAX_CITIES)
-	solution := make([]Flight, 0, graph.size)
-	home := City(0)
-	day := Day(0)
+func (w *dcfsWork) generation() int {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.gen
+}
+
+// wait until something changes after generation, false means all work is
+// done
+func (w *dcfsWork) wait(generation int) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	for w.gen == generation && w.pending > 0 && !w.stopped {
+		w.c.Wait()
+	}
+	return w.pending > 0 && !w.stopped
+}
+
+func (w *dcfsWork) pushed() {
+	w.change(0)
+}
+
+func (w *dcfsWork) finished() {
+	w.change(-1)
+}
+
+func (w *dcfsWork) change(pending int) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.pending += pending
+	w.gen++
+	w.c.Broadcast()
+}
+
+func (w *dcfsWork) stop() {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.stopped = true
+	w.c.Broadcast()
+}
+
+// dcfsTask is subtree of the search, its root is the partial route
+type dcfsTask struct {
+	partial []Flight
+	visited []City
+	price   Money
+	key     nogoodKey // of visited cities, see nogoods
+	run     int       // of the restart schedule
+}
+
+// dcfsQueue holds subtrees of one worker, it takes them from the front
+// while others steal from the back
+type dcfsQueue struct {
+	m     sync.Mutex
+	tasks []dcfsTask
+}
+
+// push puts subtree cut by restart behind the others
+func (q *dcfsQueue) push(t dcfsTask) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.tasks = append(q.tasks, t)
+}
+
+func (q *dcfsQueue) pop() (dcfsTask, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if len(q.tasks) == 0 {
+		return dcfsTask{}, false
+	}
+	t := q.tasks[0]
+	q.tasks = q.tasks[1:]
+	return t, true
+}
+
+func (q *dcfsQueue) steal() (dcfsTask, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if len(q.tasks) == 0 {
+		return dcfsTask{}, false
+	}
+	t := q.tasks[len(q.tasks)-1]
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return t, true
+}
+
+// dcfsSplit collects roots of subtrees depth flights below t in the order
+// the search would visit them, routes complete earlier are roots too
+func dcfsSplit(t dcfsTask, depth int, graph Graph, stats FlightStatistics, state *dcfsState, tasks *[]dcfsTask) {
+	day := Day(len(t.partial))
+	if depth == 0 || int(day) == graph.size {
+		*tasks = append(*tasks, t)
+		return
+	}
+	current := graph.source
+	if day > 0 {
+		current = t.partial[day-1].To
+	}
+	flights, _ := dcfsFlights(day, current, t.visited, graph, stats, state, nil)
+	for _, f := range flights {
+		// every subtree gets its own copy, searches append to them
+		partial := make([]Flight, len(t.partial), graph.size)
+		copy(partial, t.partial)
+		visited := make([]City, len(t.visited), graph.size)
+		copy(visited, t.visited)
+		child := dcfsTask{append(partial, f.flight), append(visited, f.flight.To), t.price + f.flight.Cost,
+			state.nogoods.visit(t.key, f.flight.To), 0}
+		dcfsSplit(child, depth-1, graph, stats, state, tasks)
+	}
+}
+
+// continue the search from given prefix of a solution, used to restart
+// search from the best solutions found so far; the caller is responsible
+// for keeping the rest of the route short enough to be searched
+func dcfsRestart(graph Graph, stats FlightStatistics, state *dcfsState, comm comm, prefix []Flight) {
+	visited := make([]City, 0, graph.size)
+	partial := make([]Flight, 0, graph.size)
 	price := Money(0)
-	dcfsIterate(solution, day, home, visited, graph, stats, price, comm, skip)
+	for _, f := range prefix {
+		visited = append(visited, f.To)
+		partial = append(partial, f)
+		price += f.Cost
+	}
+	last := prefix[len(prefix)-1]
+	dcfsIterate(partial, last.Day+1, last.To, visited, graph, stats, state, price, comm, nil, nogoodKey{})
 }
 
+// dcfsIterate searches subtree of partial route, cut means restart cut
+// the search and it has to be searched again, complete that no flight of
+// the subtree was left out so it can be recorded as nogood
 func dcfsIterate(partial []Flight, day Day, current City,
-	visited []City, graph Graph, stats FlightStatistics, price Money, comm comm, skip int) {
+	visited []City, graph Graph, stats FlightStatistics, state *dcfsState, price Money, comm comm,
+	r *restarts, key nogoodKey) (cut, complete bool) {
 
-	DcfsBranchCounter[day] += 1
-	if price >= dcfsCurrentBest {
+	comm.yield()
+	if r.step() {
+		return true, false
+	}
+	if price >= state.best.get() {
 		// we have already got worse than best result, give it up, bro
-		DcfsResultsCounter++
-		return
+		return false, true
 	}
 	if int(day) == graph.size {
-		DcfsResultsCounter++
-		if price < dcfsCurrentBest {
-			//dcfsCurrentBest = price
-			dcfsCurrentBest = comm.sendSolution(NewSolution(partial))
+		state.best.set(comm.sendSolution(NewSolution(partial)))
+		return false, true
+	}
+	s := r.state(key, current, day)
+	if r.failed(s, price) {
+		return false, true
+	}
+	flights, complete := dcfsFlights(day, current, visited, graph, stats, state, r)
+	for _, f := range flights {
+		cut, searched := dcfsIterate(append(partial, f.flight),
+			day+1,
+			f.flight.To,
+			append(visited, f.flight.To),
+			//dcfsInsertVisited(visited, f.flight.To),
+			graph, stats, state,
+			price+f.flight.Cost,
+			comm, r, r.visit(key, f.flight.To))
+		if cut {
+			return true, false
 		}
-		return
+		complete = complete && searched
 	}
+	if complete {
+		// flights left out could lead somewhere the next run
+		r.fail(s, price)
+	}
+	return false, complete //[]Flight{}
+}
+
+// flights within this difference of deal are near-equal for restarts
+const dcfsTie = 10
+
+// flights worth trying from current city on the day, most promising first,
+// complete tells no flight to unvisited city was left out
+func dcfsFlights(day Day, current City, visited []City, graph Graph, stats FlightStatistics, state *dcfsState, r *restarts) ([]EvaluatedFlight, bool) {
+	complete := true
 	//fmt.Fprintln(os.Stderr, "I am at", current, "day is", day)
 	var current_deal float32
 	//var current_deal int32
-	possible_flights := make([]EvaluatedFlight, 0, MAX_CITIES)
-	for _, f := range graph.fromDaySortedCost[current][day] {
+	possible_flights := make([]EvaluatedFlight, 0, graph.size)
+	for i, f := range graph.fromDay(current, day) {
 		//printInfo(f)
 		if contains(visited, f.To) {
 			//if dcfsVisited(visited, f.To) {
 			continue
 		}
+		if state.learned != nil {
+			// model replaces the discount rules, flights likely to be
+			// part of the cheapest route are the best deals
+			p := state.learned[int(graph.fromDayIndex(current, day))+i]
+			possible_flights = dcfsInsertSortedFlight(possible_flights, EvaluatedFlight{f, float32((1 - p) * dcfsLearnedScale)})
+			continue
+		}
 		s := stats.ByDest[current][f.To]
 		discount := s.AvgPrice - float32(f.Cost)
 		discount_rate := discount / float32(f.Cost)
@@ -186,8 +436,9 @@ func dcfsIterate(partial []Flight, day Day, current City,
 			s2 = stats.ByDay[f.To][day+1]
 		}
 		//if discount_rate < -0.3 {
-		if f.Cost > pDiscountThreshold && discount_rate < pMinDiscount {
+		if f.Cost > state.discountThreshold && discount_rate < state.minDiscount {
 			// no discount, no deal, bro
+			complete = false
 			continue
 		}
 		//current_deal = float32(f.Cost) - s.AvgPrice * discount // - NO NO NO
@@ -203,37 +454,34 @@ func dcfsIterate(partial []Flight, day Day, current City,
 		//current_deal = -discount // no result total 194138
 		//current_deal = float32(f.Cost) - 0.6 * discount // (200, 300) = No, 48590, total: 187078 (disc rate < 0.3)
 		//current_deal = float32(f.Cost) - 0.6*discount // (200, 300) = 40505, 48493, total: 187010 (disc rate < 0.25, >650)
-		current_deal = float32(f.Cost) - pDiscountWeight*discount + pNextAvgWeight*s2.AvgPrice // (200, 300) = 40505, 48493, total: 187010 (disc rate < 0.25, >650)
+		current_deal = float32(f.Cost) - state.discountWeight*discount + state.nextAvgWeight*s2.AvgPrice // (200, 300) = 40505, 48493, total: 187010 (disc rate < 0.25, >650)
 
 		//possible_flights = append(possible_flights, EvaluatedFlight{f, current_deal})
-		possible_flights = dcfsInsertSortedFlight(possible_flights, EvaluatedFlight{*f, current_deal})
+		possible_flights = dcfsInsertSortedFlight(possible_flights, EvaluatedFlight{f, current_deal})
 	}
 	//sort.Sort(byValue(possible_flights))
-	if len(possible_flights) == 0 {
-		return
-	}
-	if len(possible_flights) > pMaxBranches && day > 0 {
-		possible_flights = possible_flights[:pMaxBranches]
+	// flights much worse than the previous one are not worth it
+	for i := 1; i < len(possible_flights); i++ {
+		if possible_flights[i].value-possible_flights[i-1].value > 30 {
+			possible_flights = possible_flights[:i]
+			complete = false
+			break
+		}
 	}
-	last_value := possible_flights[0].value
-	for i, f := range possible_flights {
-		if f.value-last_value > 30 {
-			return
+	if r != nil && r.run > 0 {
+		values := make([]float64, len(possible_flights))
+		for i, f := range possible_flights {
+			values[i] = float64(f.value)
 		}
-		if day == 0 && skip > i {
-			skip--
-			continue
+		shuffled := make([]EvaluatedFlight, len(possible_flights))
+		for i, o := range r.order(values, dcfsTie, 0) {
+			shuffled[i] = possible_flights[o]
 		}
-		dcfsIterate(append(partial, f.flight),
-			day+1,
-			f.flight.To,
-			append(visited, f.flight.To),
-			//dcfsInsertVisited(visited, f.flight.To),
-			graph, stats,
-			price+f.flight.Cost,
-			comm, skip)
-		last_value = f.value
-
+		possible_flights = shuffled
+	}
+	if len(possible_flights) > state.maxBranches && day > 0 {
+		possible_flights = possible_flights[:state.maxBranches]
+		complete = false
 	}
-	return //[]Flight{}
+	return possible_flights, complete
 }
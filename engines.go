@@ -4,16 +4,13 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
-    "os"
+	"os"
+	"runtime"
 	"sort"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var engines []Engine
-var graph Graph
-var best Solution
-
 type Engine interface {
 	Name() string
 	Solve(comm comm, problem Problem)
@@ -23,6 +20,10 @@ type comm interface {
 	sendSolution(r Solution) Money
 	send(r Solution, originalEngine int) Money
 	done()
+	yield()
+	idle()
+	newLimit(d time.Duration) *limit
+	elites() []Solution
 }
 
 type update struct {
@@ -36,6 +37,8 @@ type solutionComm struct {
 	queryBest     chan<- int
 	receiveBest   <-chan Money
 	searchedAll   chan<- int
+	gate          *gate
+	pool          *elitePool
 	id            int
 }
 
@@ -44,7 +47,13 @@ func (c *solutionComm) sendSolution(r Solution) Money {
 }
 
 func (c *solutionComm) send(r Solution, originalEngine int) Money {
-	c.queryBest <- c.id
+	c.gate.solution()
+	c.pool.add(r)
+	select {
+	case c.queryBest <- c.id:
+	case <-c.gate.quitting():
+		runtime.Goexit()
+	}
 	bestCost := <-c.receiveBest
 	if bestCost < r.totalCost {
 		return bestCost
@@ -54,12 +63,66 @@ func (c *solutionComm) send(r Solution, originalEngine int) Money {
 	copy(solution, r.flights)
 	sort.Sort(ByDay(solution))
 
-	c.solutionReady <- update{NewSolution(solution), c.id, originalEngine}
+	select {
+	case c.solutionReady <- update{NewSolution(solution), c.id, originalEngine}:
+	case <-c.gate.quitting():
+		runtime.Goexit()
+	}
 	return r.totalCost
 }
 
 func (c solutionComm) done() {
-	c.searchedAll <- c.id
+	select {
+	case c.searchedAll <- c.id:
+	case <-c.gate.quitting():
+	}
+}
+
+// engines call yield regularly, scheduler may pause them here
+func (c solutionComm) yield() {
+	c.gate.wait()
+}
+
+// engine has nothing to do at the moment
+func (c solutionComm) idle() {
+	c.gate.idle()
+}
+
+// limit for internal search of an engine
+func (c solutionComm) newLimit(d time.Duration) *limit {
+	if c.gate != nil && c.gate.effort {
+		return stepLimit(d)
+	}
+	return timeLimit(d)
+}
+
+// best solutions found so far by any engine, best first
+func (c solutionComm) elites() []Solution {
+	return c.pool.get()
+}
+
+// bound is cost of the best solution engines of one run know about, they
+// use it to cut off search, it is safe to use from more goroutines
+type bound struct {
+	cost uint32
+}
+
+func newBound() *bound {
+	return &bound{math.MaxInt32}
+}
+
+func (b *bound) get() Money {
+	return Money(atomic.LoadUint32(&b.cost))
+}
+
+// set lowers the bound, higher cost coming late does not raise it
+func (b *bound) set(cost Money) {
+	for {
+		old := atomic.LoadUint32(&b.cost)
+		if uint32(cost) >= old || atomic.CompareAndSwapUint32(&b.cost, old, uint32(cost)) {
+			return
+		}
+	}
 }
 
 func initBestChannels(engines int) []chan Money {
@@ -118,13 +181,28 @@ func penaltyMuchoMeta(graph Graph, penalty *penalty) MetaEngine {
 	e.p = penalty
 	return e
 }
-func randomMeta(graph Graph, penalty *penalty) MetaEngine {
+
+// learnedMeta prefers flights the model finds likely to be part of the
+// cheapest route
+func learnedMeta(graph Graph, scores []float64, penalty *penalty) MetaEngine {
+	e := MetaEngine{}
+	e.graph = graph
+	e.q = 3
+	e.name = "tlearned"
+	e.weight = initWeight(graph.size, 0.5)
+	e.h = func(f *Flight) float64 {
+		return 1 - scores[graph.index(f.From, f.Day, f.To)] + 1e-9
+	}
+	e.p = penalty
+	return e
+}
+func randomMeta(graph Graph, penalty *penalty, rndSeed int64) MetaEngine {
 	e := MetaEngine{}
 	e.graph = graph
 	e.q = 1
 	e.name = "trandom"
 	e.weight = initWeight(graph.size, 0.3)
-	seed := rand.New(rand.NewSource(time.Now().UnixNano()))
+	seed := rand.New(rand.NewSource(rndSeed))
 
 	e.h = func(f *Flight) float64 {
 		return seed.Float64()
@@ -133,54 +211,75 @@ func randomMeta(graph Graph, penalty *penalty) MetaEngine {
 	return e
 }
 
-func initEngines(p Problem) ([]Engine, Polisher) {
-	graph = NewGraph(p)
-	printInfo("Graph ready")
-	polisher := NewPolisher(graph)
+// engines keep all their state in the graph and in the engines created
+// here, so more problems can be solved at the same time
+func initEngines(p Problem, graph Graph, o Options) ([]Engine, Polisher) {
+	seed := newSeeder(o.Seed)
+	ps := params(o.Params)
+	dcfs := newDcfsState(p, ps)
+	var learned []float64
+	if o.Model != nil {
+		learned = o.Model.scores(graph, p.stats)
+		dcfs.learned = learned
+	}
+	workers := dcfsWorkers(o.Deterministic)
+	polisher := NewPolisher(graph, seed.next(), o.Deterministic)
 	singleEngine := os.Getenv("FSP_ENGINE")
 	printInfo("FSP_ENGINE:", singleEngine)
 	if len(singleEngine) > 1 {
 		switch singleEngine {
 		case "DCFS":
-			return []Engine{Dcfs{graph, 0}, polisher}, polisher
+			return []Engine{Dcfs{graph, workers, seed.next(), dcfs}, polisher}, polisher
 		case "SITM":
-			return []Engine{Sitm{graph, 0}, polisher}, polisher
+			return []Engine{Sitm{graph, 0, newSitmState(graph, ps)}, polisher}, polisher
 		case "BHDFS":
-			return []Engine{Bhdfs{graph, 0}, polisher}, polisher
+			return []Engine{Bhdfs{graph, 0, newBound()}, polisher}, polisher
 		case "MITM":
 			return []Engine{Mitm{}, polisher}, polisher
 		case "BN":
-			return []Engine{NewBottleneck(graph), polisher}, polisher
+			return []Engine{NewBottleneck(graph, seed.next()), polisher}, polisher
 		case "GREEDY":
-			return []Engine{NewGreedy(graph), polisher}, polisher
+			return []Engine{NewGreedy(graph, seed.next()), polisher}, polisher
 		case "ROUNDS":
-			return []Engine{NewGreedyRounds(graph), polisher}, polisher
+			return []Engine{NewGreedyRounds(graph, seed.next()), polisher}, polisher
 		case "RANDOM":
-			return []Engine{RandomEngine{graph, 0}, polisher}, polisher
+			return []Engine{RandomEngine{graph, seed.next()}, polisher}, polisher
 		case "ANT":
-			return []Engine{AntEngine{graph, 0}, polisher}, polisher
+			return []Engine{AntEngine{graph, seed.next(), newAntParams(ps)}, polisher}, polisher
+		case "LK":
+			return []Engine{Dcfs{graph, workers, seed.next(), dcfs}, NewLk(graph, seed.next()), polisher}, polisher
+		case "BEAM":
+			return []Engine{NewBeam(graph, p.stats, seed.next()), polisher}, polisher
+		case "BIDIR":
+			return []Engine{NewBidir(graph, seed.next()), polisher}, polisher
+		case "CROSS":
+			return []Engine{Dcfs{graph, workers, seed.next(), dcfs}, NewCrossover(graph, p.stats, dcfs, seed.next()), polisher}, polisher
 		}
 	}
-	penalty := &penalty{0, &sync.Mutex{}}
-	return []Engine{
-		NewGreedy(graph),
-		NewBottleneck(graph),
-		Dcfs{graph, 0}, // single instance runs from start
-		Dcfs{graph, 1}, // additional instances can start with n-th branch in 1st level
-		//Dcfs{graph, 2},
-		AntEngine{graph, 0},
-		//Dcfs{graph, 3},
+	penalty := newPenalty(graph)
+	engines := []Engine{
+		NewGreedy(graph, seed.next()),
+		NewBottleneck(graph, seed.next()),
+		Dcfs{graph, workers, seed.next(), dcfs},
+		NewBeam(graph, p.stats, seed.next()),
+		NewBidir(graph, seed.next()),
+		AntEngine{graph, seed.next(), newAntParams(ps)},
 		//Mitm{},
-		Sitm{graph, 0},
-		//Bhdfs{graph, 0},
-		//Bhdfs{graph, 1}, // we should avoid running evaluation phase of Bhdfs more than once
+		Sitm{graph, 0, newSitmState(graph, ps)},
+		//Bhdfs{graph, 0, newBound()},
+		//Bhdfs{graph, 1, newBound()}, // we should avoid running evaluation phase of Bhdfs more than once
 		greedyMeta(graph, penalty),
 		greedyMuchoMeta(graph, penalty),
 		//discountMeta(graph, p.stats, penalty),
 		penaltyMuchoMeta(graph, penalty),
-		randomMeta(graph, penalty),
-		polisher,
-	}, polisher
+		randomMeta(graph, penalty, seed.next()),
+		NewCrossover(graph, p.stats, dcfs, seed.next()),
+		NewLk(graph, seed.next()),
+	}
+	if learned != nil {
+		engines = append(engines, learnedMeta(graph, learned, penalty))
+	}
+	return append(engines, polisher), polisher
 }
 
 func sameFlight(f1, f2 Flight) bool {
@@ -233,7 +332,9 @@ func saveBest(b *Solution, r Solution, engine string) bool {
 	return false
 }
 
-func runEngine(e Engine, comm comm, problem Problem) {
+func runEngine(e Engine, comm *solutionComm, problem Problem) {
+	comm.gate.begin()
+	defer comm.gate.finish()
 	defer func() {
 		if r := recover(); r != nil {
 			printInfo("!!! Engine", e.Name(), "panicked", r)
@@ -249,9 +350,91 @@ func getEngineLabel(e []Engine, u update) string {
 	return fmt.Sprintf("%s(%s)", e[u.engineId].Name(), e[u.originalEngine].Name())
 }
 
-func kickTheEngines(problem Problem, timeout <-chan time.Time) (Solution, error) {
+// deterministic mode, let engine which has the turn finish it, so that
+// nothing keeps running after we return
+func waitForTurn(over <-chan int, bestQuery <-chan int, bestResponse []chan Money,
+	sol <-chan update, done <-chan int, best Money) {
+	for {
+		select {
+		case <-over:
+			return
+		case i := <-bestQuery:
+			bestResponse[i] <- best
+		case <-sol:
+		case <-done:
+		}
+	}
+}
+
+// notifier calls OnImprovement from its own goroutine so that slow callback
+// does not stall the coordinator, improvements coming while it runs replace
+// each other and only the latest one is delivered
+type notifier struct {
+	latest   chan Improvement
+	finished chan struct{}
+}
+
+func newNotifier(f func(Improvement)) *notifier {
+	n := &notifier{make(chan Improvement, 1), make(chan struct{})}
+	go func() {
+		for i := range n.latest {
+			f(i)
+		}
+		close(n.finished)
+	}()
+	return n
+}
+
+// send never blocks, the coordinator is the only sender so the buffer is
+// free once the older improvement is taken out
+func (n *notifier) send(i Improvement) {
+	select {
+	case <-n.latest:
+	default:
+	}
+	n.latest <- i
+}
+
+// close waits until the latest improvement is delivered
+func (n *notifier) close() {
+	close(n.latest)
+	<-n.finished
+}
+
+// prepared is what has to be built before the engines can start
+type prepared struct {
+	graph    Graph
+	engines  []Engine
+	polisher Polisher
+	err      error
+}
+
+func kickTheEngines(problem Problem, timeout <-chan time.Time, o Options) (Solution, Report, error) {
 	nCities := problem.n
-	engines, polisher := initEngines(problem)
+	start := time.Now()
+	// building the graph of a big problem takes a while, stay responsive
+	ready := make(chan prepared, 1)
+	go func() {
+		graph := NewGraph(problem)
+		printInfo("Graph ready")
+		engines, polisher := initEngines(problem, graph, o)
+		ready <- prepared{graph, engines, polisher, feasible(graph)}
+	}()
+	var p prepared
+	select {
+	case p = <-ready:
+	case <-timeout:
+		printInfo("Out of time before the search started!")
+		return Solution{}, Report{Seed: o.Seed, Elapsed: time.Since(start), Stop: stopTimeout}, ErrNoSolution
+	case <-o.Cancel:
+		printInfo("Cancelled before the search started!")
+		return Solution{}, Report{Seed: o.Seed, Elapsed: time.Since(start), Stop: stopCancelled}, ErrNoSolution
+	}
+	graph, engines, polisher := p.graph, p.engines, p.polisher
+	if err := p.err; err != nil {
+		printInfo("Infeasible:", err)
+		return Solution{}, Report{Seed: o.Seed, Pruned: graph.pruned(len(problem.flights)), Stop: stopInfeasible}, err
+	}
 
 	//query/response what is current best
 	bestResponse := initBestChannels(len(engines))
@@ -259,27 +442,108 @@ func kickTheEngines(problem Problem, timeout <-chan time.Time) (Solution, error)
 
 	//signalize goroutine they can write to their buffer
 	sol := make(chan update, len(engines))
-	best = Solution{make([]Flight, nCities), math.MaxInt32}
+	best := Solution{make([]Flight, nCities), math.MaxInt32}
 
 	//goroutine signals it has searched the entire state space, we can finish
 	done := make(chan int)
 
+	pool := newElitePool(nCities)
+	sched := newScheduler(engines, polisher, o)
+	defer sched.stop()
+	defer polisher.queue.close()
+	var tickC <-chan time.Time
+	if !o.Deterministic {
+		tick := time.NewTicker(schedTick)
+		defer tick.Stop()
+		tickC = tick.C
+	}
+
+	budget := newBudget(o, engines, sched.gates)
+	budget.report.Pruned = graph.pruned(len(problem.flights))
+	var budgetC <-chan time.Time
+	if !o.Deterministic && o.budgeted() {
+		tick := time.NewTicker(budgetTick)
+		defer tick.Stop()
+		budgetC = tick.C
+	}
+
 	for i, e := range engines {
-		go runEngine(e, &solutionComm{sol, bestQuery, bestResponse[i], done, i}, problem)
+		go runEngine(e, &solutionComm{sol, bestQuery, bestResponse[i], done, sched.gates[i], pool, i}, problem)
+	}
+	if o.Deterministic {
+		sched.grant()
+	}
+	var notify *notifier
+	if o.OnImprovement != nil {
+		notify = newNotifier(o.OnImprovement)
+		// caller gets the last improvement before solve returns
+		defer notify.close()
+	}
+	save := func(u update) {
+		label := getEngineLabel(engines, u)
+		if saveBest(&best, u.solution, label) {
+			sched.improved(u.engineId)
+			budget.improvement()
+			if notify != nil {
+				flights := make([]Flight, len(best.flights))
+				copy(flights, best.flights)
+				notify.send(Improvement{Solution{flights, best.totalCost}, label, budget.report.BestFound})
+			}
+		}
+		polisher.try(u)
+	}
+	// solutions engine has sent already are processed before it gets
+	// another answer or before next engine runs, keeps the run repeatable
+	drain := func() {
+		for len(sol) > 0 {
+			save(<-sol)
+		}
 	}
 	for {
 		select {
 		case u := <-sol:
-			saveBest(&best, u.solution, getEngineLabel(engines, u))
-			polisher.try(u)
+			save(u)
+		case <-tickC:
+			sched.reschedule()
+		case <-budgetC:
+			if reason := budget.exhausted(); reason != "" {
+				return best, budget.stop(reason), nil
+			}
+		case <-sched.over:
+			drain()
+			if !sched.next() {
+				printInfo("All engines finished")
+				return best, budget.stop(stopFinished), nil
+			}
+			if o.Rounds > 0 && sched.rounds >= o.Rounds {
+				printInfo("Rounds finished:", sched.rounds)
+				return best, budget.stop(stopRounds), nil
+			}
+			if reason := budget.exhausted(); reason != "" {
+				return best, budget.stop(reason), nil
+			}
+			sched.grant()
 		case i := <-bestQuery:
+			drain()
 			bestResponse[i] <- best.totalCost
 		case i := <-done:
 			printInfo("Fearles engine", engines[i].Name(), "thinks it's done, let's see")
-			return best, nil
+			if o.Deterministic {
+				waitForTurn(sched.over, bestQuery, bestResponse, sol, done, best.totalCost)
+			}
+			return best, budget.stop(stopFinished), nil
 		case <-timeout:
 			printInfo("Out of time!")
-			return best, nil
+			if o.Deterministic {
+				waitForTurn(sched.over, bestQuery, bestResponse, sol, done, best.totalCost)
+			}
+			return best, budget.stop(stopTimeout), nil
+		case <-o.Cancel:
+			printInfo("Cancelled!")
+			if o.Deterministic {
+				waitForTurn(sched.over, bestQuery, bestResponse, sol, done, best.totalCost)
+			}
+			return best, budget.stop(stopCancelled), nil
 		}
 	}
 }
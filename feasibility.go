@@ -0,0 +1,158 @@
+package fsp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoSolution is returned when search ended without finding any route
+// although problem was not found infeasible upfront
+var ErrNoSolution = errors.New("no solution found")
+
+// Infeasibility is one reason why there is no valid route, it concerns
+// either some cities or some days
+type Infeasibility struct {
+	Reason string
+	Cities []City
+	Days   []Day
+}
+
+// InfeasibleError is returned by Solve when problem has no valid route
+type InfeasibleError struct {
+	Reasons []Infeasibility
+}
+
+func (e *InfeasibleError) Error() string {
+	return e.Format(func(c City) string { return fmt.Sprint(c) })
+}
+
+// Format describes the error using given names of the cities
+func (e *InfeasibleError) Format(name func(City) string) string {
+	reasons := make([]string, 0, len(e.Reasons))
+	for _, r := range e.Reasons {
+		what := make([]string, 0, len(r.Cities)+len(r.Days))
+		for _, c := range r.Cities {
+			what = append(what, name(c))
+		}
+		for _, d := range r.Days {
+			what = append(what, fmt.Sprint(d))
+		}
+		if len(r.Cities) > 0 && len(r.Days) > 0 {
+			cities, days := what[:len(r.Cities)], what[len(r.Cities):]
+			reasons = append(reasons, fmt.Sprintf("%s: cities %s on days %s", r.Reason, strings.Join(cities, ", "), strings.Join(days, ", ")))
+		} else if len(r.Cities) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s: cities %s", r.Reason, strings.Join(what, ", ")))
+		} else if len(r.Days) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s: days %s", r.Reason, strings.Join(what, ", ")))
+		} else {
+			reasons = append(reasons, r.Reason)
+		}
+	}
+	return "no valid route, " + strings.Join(reasons, "; ")
+}
+
+// reasons of infeasibility of cities, most specific first
+const (
+	noInbound  = "no inbound flight on any usable day"
+	noOutbound = "no outbound flight on any usable day"
+	unreached  = "cannot be reached from start city"
+	noReturn   = "cannot get back to start city from there"
+	noVisit    = "cannot be reached on a day from which start city can still be reached"
+	sameDay    = "have to be visited on the same day"
+)
+
+// feasible looks for obvious reasons why there is no route, it ignores
+// that cities must not be visited twice so passing it does not guarantee
+// there is a route
+func feasible(g Graph) error {
+	n := g.size
+	if n < 2 {
+		return nil
+	}
+	var reasons []Infeasibility
+	var empty []Day
+	for day := 0; day < n; day++ {
+		flights := 0
+		for c := 0; c < n; c++ {
+			flights += len(g.fromDay(City(c), Day(day)))
+		}
+		if flights == 0 {
+			empty = append(empty, Day(day))
+		}
+	}
+	if len(empty) > 0 && int(empty[len(empty)-1]) == n-1 {
+		empty = empty[:len(empty)-1]
+		reasons = append(reasons, Infeasibility{Reason: "no flight back to start city on the last day"})
+	}
+	if len(empty) > 0 {
+		reasons = append(reasons, Infeasibility{Reason: "no flights", Days: empty})
+	}
+
+	reach := g.reachable()
+	ret := g.returning()
+	cities := make(map[string][]City)
+	for c := City(0); int(c) < n; c++ {
+		if c == g.source {
+			continue
+		}
+		var inbound, outbound, reached, returns, visit bool
+		for day := 0; day < n-1; day++ {
+			inbound = inbound || len(g.toDay(c, Day(day))) > 0
+			outbound = outbound || len(g.fromDay(c, Day(day+1))) > 0
+			reached = reached || reach[day][c]
+			returns = returns || ret[day+1][c]
+			visit = visit || reach[day][c] && ret[day+1][c]
+		}
+		var reason string
+		switch {
+		case !inbound:
+			reason = noInbound
+		case !outbound:
+			reason = noOutbound
+		case len(reasons) > 0:
+			// days without flights cut off all cities, no need to list them
+			continue
+		case !reached:
+			reason = unreached
+		case !returns:
+			reason = noReturn
+		case !visit:
+			reason = noVisit
+		default:
+			continue
+		}
+		cities[reason] = append(cities[reason], c)
+	}
+	for _, r := range []string{noInbound, noOutbound, unreached, noReturn, noVisit} {
+		if len(cities[r]) > 0 {
+			reasons = append(reasons, Infeasibility{Reason: r, Cities: cities[r]})
+		}
+	}
+
+	if len(reasons) == 0 {
+		// every city is fine on its own, but the route may still break
+		var broken []Day
+		for day := 0; day < n-1; day++ {
+			stay := false
+			for c := 0; c < n && !stay; c++ {
+				stay = reach[day][c] && ret[day+1][c]
+			}
+			if !stay {
+				broken = append(broken, Day(day))
+			}
+		}
+		if len(broken) > 0 {
+			reasons = append(reasons, Infeasibility{Reason: "no city to be in after flight from which start city can be reached", Days: broken})
+		}
+	}
+
+	if g.conflict != nil {
+		// pruning proved it even when checks above found nothing
+		reasons = append(reasons, *g.conflict)
+	}
+	if len(reasons) > 0 {
+		return &InfeasibleError{reasons}
+	}
+	return nil
+}
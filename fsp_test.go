@@ -1,7 +1,14 @@
 package fsp
 
 import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var engines_all = []Engine{
@@ -144,7 +151,921 @@ func initComm(i int) (comm, commMaster) {
 		cm.queryBest,
 		cm.receiveBest,
 		cm.searchedAll,
+		nil,
+		nil,
 		0,
 	}
 	return comm, cm
 }
+
+// problem with flights between all cities on all days with random prices
+func randomProblem(n int, seed int64) Problem {
+	rng := rand.New(rand.NewSource(seed))
+	flights := make([]Flight, 0, n*n*n)
+	for day := 0; day < n; day++ {
+		for from := 0; from < n; from++ {
+			if (day == 0) != (from == 0) {
+				continue
+			}
+			for to := 0; to < n; to++ {
+				if to == from {
+					continue
+				}
+				cost := Money(rng.Intn(1000) + 1)
+				flights = append(flights, Flight{City(from), City(to), Day(day), cost, 0, 0.0})
+			}
+		}
+	}
+	return NewProblem(flights, n, CollectStats(flights, n))
+}
+
+// problem with a cheap route visiting cities in random order and given
+// number of other more expensive flights from every city on every day
+func sparseProblem(n, degree int, seed int64) Problem {
+	rng := rand.New(rand.NewSource(seed))
+	route := append([]int{0}, rng.Perm(n-1)...)
+	for i := 1; i < n; i++ {
+		route[i]++
+	}
+	route = append(route, 0)
+	flights := make([]Flight, 0, n*(degree+1))
+	for day := 0; day < n; day++ {
+		flights = append(flights, Flight{City(route[day]), City(route[day+1]), Day(day), Money(rng.Intn(100) + 1), 0, 0.0})
+		for from := 0; from < n; from++ {
+			if (day == 0) != (from == 0) {
+				continue
+			}
+			for i := 0; i < degree; i++ {
+				to := rng.Intn(n)
+				if day == n-1 {
+					to = 0
+				}
+				if to == from {
+					continue
+				}
+				flights = append(flights, Flight{City(from), City(to), Day(day), Money(rng.Intn(900) + 101), 0, 0.0})
+			}
+		}
+	}
+	return NewProblem(flights, n, CollectStats(flights, n))
+}
+
+func TestDeterministic(t *testing.T) {
+	o := Options{Seed: 7, Deterministic: true, Rounds: 3}
+	first, _, _ := randomProblem(12, 1).SolveWithOptions(nil, o)
+	second, _, _ := randomProblem(12, 1).SolveWithOptions(nil, o)
+	if first.totalCost == math.MaxInt32 {
+		t.Errorf("No solution found")
+	}
+	if !solutionsEqual(first, second) {
+		t.Errorf("Same seed, different solutions: '%v' and '%v'", first, second)
+	}
+	o.StallTime = time.Second
+	if _, _, err := randomProblem(12, 1).SolveWithOptions(nil, o); err == nil {
+		t.Errorf("Stall time accepted in deterministic run")
+	}
+}
+
+func TestImprovements(t *testing.T) {
+	var improvements []Improvement
+	o := Options{Seed: 7, Deterministic: true, Rounds: 1}
+	o.OnImprovement = func(i Improvement) {
+		improvements = append(improvements, i)
+	}
+	s, _, _ := randomProblem(12, 1).SolveWithOptions(nil, o)
+	if len(improvements) == 0 {
+		t.Fatalf("No improvements reported")
+	}
+	for i := 1; i < len(improvements); i++ {
+		if improvements[i].Solution.totalCost >= improvements[i-1].Solution.totalCost {
+			t.Errorf("Improvement %d is not better than previous one", i)
+		}
+	}
+	if !solutionsEqual(improvements[len(improvements)-1].Solution, s) {
+		t.Errorf("Last improvement is not the final solution")
+	}
+
+	// slow callback gets the latest improvements, the final one included
+	var last Improvement
+	calls := 0
+	o.OnImprovement = func(i Improvement) {
+		time.Sleep(20 * time.Millisecond)
+		last = i
+		calls++
+	}
+	s, _, _ = randomProblem(12, 1).SolveWithOptions(nil, o)
+	if calls == 0 || !solutionsEqual(last.Solution, s) {
+		t.Errorf("Last of %d slow improvements is not the final solution", calls)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := randomProblem(12, 1)
+	s, _, _ := p.SolveWithOptions(nil, Options{Seed: 7, Deterministic: true, Rounds: 1})
+	if err := p.Validate(s); err != nil {
+		t.Fatalf("Solution found is not valid: %v", err)
+	}
+	broken := []Solution{
+		NewSolution(append([]Flight{}, s.flights[:len(s.flights)-1]...)),
+		Solution{s.flights, s.totalCost + 1},
+	}
+	twice := append([]Flight{}, s.flights...)
+	twice[2].To = twice[1].To
+	cheaper := append([]Flight{}, s.flights...)
+	cheaper[3].Cost--
+	broken = append(broken, NewSolution(twice), NewSolution(cheaper))
+	for i, b := range broken {
+		if err := p.Validate(b); err == nil {
+			t.Errorf("Broken solution %d is valid", i)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	n := 6
+	a := randomProblem(n, 1).Analyze()
+	if a.Density != 1.0 {
+		t.Errorf("Full problem has density %v", a.Density)
+	}
+	if a.ByDay[0].MinOutDegree != n-1 || a.ByDay[1].MinOutDegree != n-2 || a.ByDay[n-1].MinOutDegree != 1 {
+		t.Errorf("Unexpected out-degrees %v", a.ByDay)
+	}
+	if a.ByDay[n-2].Reachable != n-1 || a.ByDay[n-1].Reachable != 1 {
+		t.Errorf("Unexpected reachable cities %v", a.ByDay)
+	}
+	if a.Flights != (n-1)+(n-1)*(n-1)*(n-1) {
+		t.Errorf("Unexpected number of flights %d", a.Flights)
+	}
+
+	// pruning would drop the dead end in city 1 on day 2
+	dead := NewProblem([]Flight{
+		{0, 1, 0, 10, 0, 0.0},
+		{0, 2, 0, 10, 0, 0.0},
+		{1, 2, 1, 10, 0, 0.0},
+		{2, 1, 1, 10, 0, 0.0},
+		{2, 0, 2, 10, 0, 0.0},
+	}, 3, FlightStatistics{}).Analyze()
+	if dead.ByDay[2].MinOutDegree != 0 || dead.ByDay[1].Reachable != 2 {
+		t.Errorf("Expected dead end on day 2, got %v", dead.ByDay)
+	}
+}
+
+func TestInfeasible(t *testing.T) {
+	n := 5
+	full := randomProblem(n, 1)
+	// nobody flies to city 3
+	flights := make([]Flight, 0, len(full.flights))
+	for _, f := range full.flights {
+		if f.To != 3 {
+			flights = append(flights, f)
+		}
+	}
+	_, _, err := NewProblem(flights, n, full.stats).SolveWithOptions(nil, Options{Seed: 7, Deterministic: true, Rounds: 1})
+	e, ok := err.(*InfeasibleError)
+	if !ok {
+		t.Fatalf("Expected infeasible error, got %v", err)
+	}
+	if len(e.Reasons) != 1 || e.Reasons[0].Reason != noInbound || len(e.Reasons[0].Cities) != 1 || e.Reasons[0].Cities[0] != 3 {
+		t.Errorf("Unexpected reasons: %v", e)
+	}
+
+	// cities 2 and 3 can only be reached on day 1
+	p := NewProblem([]Flight{
+		{0, 1, 0, 10, 0, 0.0},
+		{1, 2, 1, 10, 0, 0.0},
+		{1, 3, 1, 10, 0, 0.0},
+		{2, 1, 2, 10, 0, 0.0},
+		{3, 1, 2, 10, 0, 0.0},
+		{1, 0, 3, 10, 0, 0.0},
+	}, 4, FlightStatistics{})
+	_, _, err = p.SolveWithOptions(nil, Options{Seed: 7, Deterministic: true, Rounds: 1})
+	e, ok = err.(*InfeasibleError)
+	if !ok {
+		t.Fatalf("Expected infeasible error, got %v", err)
+	}
+	r := e.Reasons[len(e.Reasons)-1]
+	if r.Reason != sameDay || len(r.Cities) != 2 || r.Cities[0] != 2 || r.Cities[1] != 3 || len(r.Days) != 1 || r.Days[0] != 1 {
+		t.Errorf("Unexpected reasons: %v", e)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	p := NewProblem([]Flight{
+		{0, 1, 0, 10, 0, 0.0},
+		{0, 1, 0, 20, 0, 0.0}, // more expensive duplicate
+		{0, 2, 0, 10, 0, 0.0}, // only way on leads to 1 with no flight home
+		{1, 2, 1, 10, 0, 0.0},
+		{2, 1, 1, 10, 0, 0.0},
+		{2, 0, 2, 10, 0, 0.0},
+	}, 3, FlightStatistics{})
+	keep, conflict := prune(p, 3, 0)
+	if conflict != nil {
+		t.Errorf("Unexpected conflict %v", conflict)
+	}
+	expected := []bool{true, false, false, true, false, true}
+	for i := range keep {
+		if keep[i] != expected[i] {
+			t.Errorf("Flight %v kept: %v, expected %v", p.flights[i], keep[i], expected[i])
+		}
+	}
+}
+
+func TestThousandCities(t *testing.T) {
+	if testing.Short() {
+		t.Skip("big problem")
+	}
+	p := sparseProblem(1000, 3, 1)
+	// a budget rather than wall time, the graph alone takes seconds under -race
+	s, _, err := p.SolveWithOptions(nil, Options{Seed: 7, Deterministic: true, MaxSolutions: 1})
+	if err != nil {
+		t.Fatalf("No solution found: %v", err)
+	}
+	if err := p.Validate(s); err != nil {
+		t.Errorf("Solution found is not valid: %v", err)
+	}
+	// cancel has to be noticed while the graph is still being built
+	cancel := make(chan struct{})
+	close(cancel)
+	_, r, err := p.SolveWithOptions(nil, Options{Seed: 7, Cancel: cancel})
+	if err != ErrNoSolution || r.Stop != stopCancelled {
+		t.Errorf("Cancelled during graph build: %v, %q", err, r.Stop)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, d := range []Distribution{Uniform, HubAndSpoke, Seasonal} {
+		o := GeneratorOptions{Cities: 10, Density: 0.3, MinPrice: 10, MaxPrice: 100,
+			Distribution: d, Optimal: true, Bottlenecks: 2, Seed: 1}
+		p, route, err := Generate(o)
+		if err != nil {
+			t.Fatalf("%v: %v", d, err)
+		}
+		if err := p.Validate(route); err != nil {
+			t.Errorf("%v: planted route is not valid: %v", d, err)
+		}
+		inbound := make([]int, p.n)
+		for _, f := range p.flights {
+			inbound[f.To]++
+		}
+		bottlenecks := 0
+		for _, i := range inbound {
+			if i == 1 {
+				bottlenecks++
+			}
+		}
+		if bottlenecks < o.Bottlenecks {
+			t.Errorf("%v: %d bottlenecks, expected %d", d, bottlenecks, o.Bottlenecks)
+		}
+		s, _, _ := p.SolveWithOptions(nil, Options{Seed: 7, Deterministic: true, Rounds: 3})
+		if s.totalCost < route.totalCost {
+			t.Errorf("%v: found %d, cheaper than optimal %d", d, s.totalCost, route.totalCost)
+		}
+	}
+}
+
+func TestReentrant(t *testing.T) {
+	o := Options{Seed: 7, Deterministic: true, Rounds: 3}
+	problems := []Problem{randomProblem(12, 1), randomProblem(15, 2), randomProblem(12, 1)}
+	expected := make([]Solution, len(problems))
+	for i, p := range problems {
+		expected[i], _, _ = p.SolveWithOptions(nil, o)
+	}
+	goroutines := runtime.NumGoroutine()
+	got := make([]Solution, len(problems))
+	var wg sync.WaitGroup
+	for i := range problems {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], _, _ = problems[i].SolveWithOptions(nil, o)
+		}(i)
+	}
+	wg.Wait()
+	for i := range problems {
+		if !solutionsEqual(expected[i], got[i]) {
+			t.Errorf("Problem %d solved alone '%v', in parallel '%v'", i, expected[i], got[i])
+		}
+	}
+	// engines exit once solving is over
+	for wait := 0; runtime.NumGoroutine() > goroutines && wait < 100; wait++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines left running", n-goroutines)
+	}
+}
+
+func TestParams(t *testing.T) {
+	p := randomProblem(12, 1)
+	o := Options{Seed: 7, Deterministic: true, Rounds: 1, Params: map[string]float64{
+		"DCFS_MAX_BRANCHES": 1,
+		"ANT_PRICE_C":       3,
+	}}
+	s, _, err := p.SolveWithOptions(nil, o)
+	if err != nil {
+		t.Fatalf("Solving with params failed: %v", err)
+	}
+	if err := p.Validate(s); err != nil {
+		t.Errorf("Solution found with params is not valid: %v", err)
+	}
+	o.Params["NO_SUCH_PARAM"] = 1
+	if _, _, err := p.SolveWithOptions(nil, o); err == nil {
+		t.Error("Expected unknown parameter to fail")
+	}
+}
+
+func TestPolisherMoves(t *testing.T) {
+	// route 0 1 2 3 4 5 0 with flights for 2-opt giving 0 4 3 2 1 5 0 and
+	// Or-opt giving 0 2 3 4 1 5 0
+	route := []Flight{
+		{0, 1, 0, 10, 0, 0}, {1, 2, 1, 10, 0, 0}, {2, 3, 2, 10, 0, 0},
+		{3, 4, 3, 10, 0, 0}, {4, 5, 4, 10, 0, 0}, {5, 0, 5, 10, 0, 0},
+	}
+	flights := append([]Flight{
+		{0, 4, 0, 1, 0, 0}, {4, 3, 1, 1, 0, 0}, {3, 2, 2, 1, 0, 0},
+		{2, 1, 3, 1, 0, 0}, {1, 5, 4, 1, 0, 0},
+		{0, 2, 0, 2, 0, 0}, {2, 3, 1, 2, 0, 0}, {3, 4, 2, 2, 0, 0},
+		{4, 1, 3, 2, 0, 0},
+	}, route...)
+	p := NewProblem(flights, 6, CollectStats(flights, 6))
+	g := NewGraph(p)
+	r := newRoute(route)
+	tests := []struct {
+		moved []Flight
+		cost  Money
+	}{
+		{twoOpt(g, route, r, 1, 4), 15},
+		{orOpt(g, route, r, 1, 1, 4), 19},
+		// back again
+		{orOpt(g, route, r, 4, 1, 0), 0},
+	}
+	for i, test := range tests {
+		switch {
+		case test.moved == nil && test.cost != 0:
+			t.Errorf("Move %d: no improvement found", i)
+		case test.moved != nil && Cost(test.moved) != test.cost:
+			t.Errorf("Move %d: expected %d, got '%v'", i, test.cost, test.moved)
+		case test.moved != nil:
+			if err := p.Validate(NewSolution(test.moved)); err != nil {
+				t.Errorf("Move %d: %v", i, err)
+			}
+		}
+	}
+
+	// local search has to apply both moves above one after another
+	polisher := NewPolisher(g, 1, true)
+	comm, cm := initComm(6)
+	done := make(chan int)
+	go func() {
+		polisher.ils(comm, update{NewSolution(route), 0, 0}, 1)
+		close(done)
+	}()
+	var best Solution
+	for {
+		select {
+		case u := <-cm.update:
+			best = u.solution
+		case <-cm.queryBest:
+			cm.receiveBest <- math.MaxInt32
+		case <-done:
+			if err := p.Validate(best); err != nil || best.totalCost > 15 {
+				t.Errorf("Local search ended with '%v', %v", best, err)
+			}
+			return
+		}
+	}
+}
+
+func TestPolisherLimit(t *testing.T) {
+	n := 20
+	p := randomProblem(n, 1)
+	g := NewGraph(p)
+	route := make([]Flight, n)
+	for d := range route {
+		route[d] = *g.get(City(d), Day(d), City((d+1)%n))
+	}
+	// every evaluated move counts, not only every descent step
+	l := stepLimit(time.Millisecond)
+	comm, _ := initComm(n)
+	NewPolisher(g, 1, true).descend(comm, route, l)
+	if l.steps <= l.max || l.steps > l.max+1000 {
+		t.Errorf("Expected descent to stop soon after %d steps, stopped after %d", l.max, l.steps)
+	}
+}
+
+func TestPolishQueue(t *testing.T) {
+	q := newPolishQueue(2)
+	solution := func(cost Money, cities ...City) update {
+		flights := make([]Flight, len(cities))
+		for i, c := range cities {
+			flights[i] = Flight{To: c, Day: Day(i), Cost: cost}
+		}
+		return update{Solution{flights, cost}, 0, 0}
+	}
+	pushes := []struct {
+		u        update
+		accepted bool
+	}{
+		{solution(30, 1, 2, 0), true},
+		{solution(30, 1, 2, 0), false}, // duplicate
+		{solution(20, 2, 1, 0), true},
+		{solution(40, 3, 1, 0), false}, // two cheaper ones queued
+		{solution(10, 3, 2, 0), true},  // evicts the most expensive
+	}
+	for i, p := range pushes {
+		if q.push(p.u) != p.accepted {
+			t.Errorf("Push %d: expected accepted %v", i, p.accepted)
+		}
+	}
+	for _, cost := range []Money{10, 20} {
+		if u, ok := q.pop(false); !ok || u.solution.totalCost != cost {
+			t.Errorf("Expected solution with cost %d, got %v %v", cost, u.solution, ok)
+		}
+	}
+	if _, ok := q.pop(false); ok {
+		t.Errorf("Expected empty queue")
+	}
+	done := make(chan bool)
+	go func() {
+		_, ok := q.pop(true)
+		done <- ok
+	}()
+	q.close()
+	if <-done {
+		t.Errorf("Expected closed queue to give nothing")
+	}
+}
+
+func TestLk(t *testing.T) {
+	p := randomProblem(12, 1)
+	s, _, _ := p.SolveWithOptions(nil, Options{Seed: 7, Deterministic: true, Rounds: 1})
+	g := NewGraph(p)
+	e := NewLk(g, 1)
+	comm, _ := initComm(12)
+	tour := newLkTour(g, s.flights, e.penalty)
+	if tour.value() != int64(s.totalCost) {
+		t.Fatalf("Tour costs %d, solution %d", tour.value(), s.totalCost)
+	}
+	tour.optimize(comm, e.rng, timeLimit(time.Second))
+	if tour.missing > 0 || tour.value() > int64(s.totalCost) {
+		t.Errorf("Expected complete tour not worse than %d, got %d with %d flights missing",
+			s.totalCost, tour.value(), tour.missing)
+	}
+	// exchanges making flights missing have to be repaired
+	tour.swap(2, 7)
+	tour.swap(3, 9)
+	tour.optimize(comm, e.rng, timeLimit(time.Second))
+	if tour.missing > 0 {
+		t.Errorf("Expected complete tour, %d flights missing", tour.missing)
+	}
+	if err := p.Validate(NewSolution(tour.flights())); err != nil {
+		t.Errorf("Tour is not valid: %v", err)
+	}
+	// exchanges evaluated by chains count, not only chains started
+	tour.swap(2, 7)
+	l := stepLimit(time.Microsecond)
+	tour.optimize(comm, e.rng, l)
+	if l.steps <= l.max+1 || l.steps > l.max+100 {
+		t.Errorf("Expected chains to stop soon after %d steps counting exchanges, stopped after %d", l.max, l.steps)
+	}
+}
+
+func TestBeam(t *testing.T) {
+	p, planted, _ := Generate(GeneratorOptions{Cities: 20, Density: 0.4, MinPrice: 10, MaxPrice: 500, Optimal: true, Seed: 5})
+	comm, cm := initComm(20)
+	done := make(chan int)
+	go func() {
+		NewBeam(NewGraph(p), p.stats, 1).Solve(comm, p)
+		close(done)
+	}()
+	var best Solution
+	for {
+		select {
+		case u := <-cm.update:
+			best = u.solution
+		case <-cm.queryBest:
+			cm.receiveBest <- math.MaxInt32
+		case <-done:
+			if best.totalCost != planted.totalCost {
+				t.Errorf("Expected planted route costing %d, got '%v'", planted.totalCost, best)
+			}
+			return
+		}
+	}
+}
+
+func TestBidir(t *testing.T) {
+	p, _, _ := Generate(GeneratorOptions{Cities: 20, Density: 0.4, MinPrice: 10, MaxPrice: 500, Optimal: true, Seed: 5})
+	comm, cm := initComm(20)
+	done := make(chan int)
+	go func() {
+		NewBidir(NewGraph(p), 1).Solve(comm, p)
+		close(done)
+	}()
+	var best Solution
+	for {
+		select {
+		case u := <-cm.update:
+			best = u.solution
+			if ok, err := correct(p, best); !ok {
+				t.Errorf("Invalid route '%v': %s", best, err)
+			}
+		case <-cm.queryBest:
+			cm.receiveBest <- math.MaxInt32
+		case <-done:
+			if len(best.flights) == 0 {
+				t.Errorf("Expected route, got none")
+			}
+			return
+		}
+	}
+}
+
+func TestAnt(t *testing.T) {
+	p := randomProblem(12, 1)
+	g := NewGraph(p)
+	c := newColony(g, 1, newAntParams(params{}))
+	comm, _ := initComm(12)
+	for a := 0; a < 10; a++ {
+		route := c.walk(comm)
+		if route == nil {
+			continue
+		}
+		route = c.polish(comm, route)
+		if err := p.Validate(NewSolution(route)); err != nil {
+			t.Fatalf("Ant route is not valid: %v", err)
+		}
+		if cost := Cost(route); cost < c.bestCost {
+			c.improve(route, cost)
+		}
+		c.update(route, Cost(route))
+	}
+	if c.best == nil {
+		t.Fatal("Expected ants to find a route")
+	}
+	for fi, f := range c.feromones {
+		if f < c.min || f > c.max {
+			t.Errorf("Feromones %f of flight %d out of bounds [%f, %f]", f, fi, c.min, c.max)
+		}
+	}
+}
+
+func TestDcfsWorkers(t *testing.T) {
+	p := randomProblem(9, 3)
+	g := NewGraph(p)
+	solve := func(workers int) Money {
+		comm, cm := initComm(9)
+		done := make(chan int)
+		go func() {
+			state := newDcfsState(p, params{"DCFS_MAX_BRANCHES": 9, "DCFS_SPLIT_DEPTH": 3})
+			Dcfs{g, workers, 1, state}.Solve(comm, p)
+			close(done)
+		}()
+		best := Money(math.MaxInt32)
+		for {
+			select {
+			case u := <-cm.update:
+				if err := p.Validate(u.solution); err != nil {
+					t.Errorf("Invalid route of %d workers: %v", workers, err)
+				}
+				if u.solution.totalCost < best {
+					best = u.solution.totalCost
+				}
+			case <-cm.queryBest:
+				cm.receiveBest <- best
+			case <-done:
+				return best
+			}
+		}
+	}
+	// whole tree is searched no matter who searches which subtree
+	one, more := solve(1), solve(3)
+	if one == math.MaxInt32 {
+		t.Fatal("Expected Dcfs to find a route")
+	}
+	if one != more {
+		t.Errorf("Expected the same best price, 1 worker found %d, 3 workers %d", one, more)
+	}
+}
+
+func TestRestarts(t *testing.T) {
+	expected := []int{1, 1, 2, 1, 1, 2, 4, 1, 1, 2, 1, 1, 2, 4, 8}
+	schedule := lubySchedule(10)
+	for i, e := range expected {
+		if got := schedule(i); got != 10*e {
+			t.Errorf("Expected run %d of Luby schedule to get %d nodes, got %d", i, 10*e, got)
+		}
+	}
+	r := newRestarts(geometricSchedule(10, 2), 1, newNogoods(5, 1))
+	r.next()
+	r.next()
+	for i := 0; i < 40; i++ {
+		if r.step() {
+			t.Fatalf("Run 2 cut after %d nodes, expected 40", i)
+		}
+	}
+	if !r.step() {
+		t.Error("Expected run 2 to be cut after 40 nodes")
+	}
+
+	// near-equal values stay in their group
+	values := []float64{10, 10.5, 11, 20, 20, 35}
+	for k := 0; k < 20; k++ {
+		order := r.order(values, 1, 0)
+		for i, o := range order {
+			if values[o]-values[i] > 1 || values[i]-values[o] > 1 {
+				t.Fatalf("Value %v moved to %v in order %v", values[o], values[i], order)
+			}
+		}
+	}
+	// groups are near-equal to their own first value
+	values = []float64{100, 104, 108, 112, 200}
+	for k := 0; k < 20; k++ {
+		order := r.order(values, 0, 0.05)
+		if order[2] != 2 && order[2] != 3 || order[4] != 4 {
+			t.Fatalf("Values of group starting at 108 mixed with others in order %v", order)
+		}
+	}
+
+	key := r.visit(r.start(0), 3)
+	state := r.state(key, 3, 1)
+	r.fail(state, 100)
+	if !r.failed(state, 100) || !r.failed(state, 120) || r.failed(state, 90) {
+		t.Error("Expected state to fail only at price 100 or more")
+	}
+	if r.failed(r.state(key, 2, 1), 200) {
+		t.Error("Expected state in another city not to fail")
+	}
+	// other cities visited, hash collision is not taken for the same state
+	collision := state
+	collision.bits = r.visit(key, 4).bits
+	if r.failed(collision, 200) {
+		t.Error("Expected state with the same hash and other visited cities not to fail")
+	}
+
+	// subtrees searched only partly are not nogoods
+	p := randomProblem(7, 1)
+	ds := newDcfsState(p, params{"DCFS_MAX_BRANCHES": 1})
+	comm, cm := initComm(7)
+	go func() {
+		for range cm.queryBest {
+			cm.receiveBest <- math.MaxInt32
+		}
+	}()
+	go func() {
+		for range cm.update {
+		}
+	}()
+	r = newRestarts(lubySchedule(1000000), 1, ds.nogoods)
+	g := NewGraph(p)
+	dcfsIterate(make([]Flight, 0, 7), 0, 0, nil, g, p.stats, ds, 0, comm, r, r.start(0))
+	first, _ := dcfsFlights(0, 0, nil, g, p.stats, ds, nil)
+	for _, f := range first {
+		if r.failed(r.state(r.visit(r.start(0), f.flight.To), f.flight.To, 1), math.MaxInt32) {
+			t.Errorf("Expected no nogood in city %d on day 1 searched by one branch", f.flight.To)
+		}
+	}
+	if r.failed(r.state(r.start(0), 0, 0), math.MaxInt32) {
+		t.Error("Expected no nogood at the start")
+	}
+}
+
+func TestModel(t *testing.T) {
+	p, planted, _ := Generate(GeneratorOptions{Cities: 15, Density: 0.5, MinPrice: 10, MaxPrice: 500, Optimal: true, Seed: 7})
+	x, y := TrainingExamples(p, planted)
+	m := TrainModel(x, y, 300, 0.5)
+	if err := m.check(); err != nil {
+		t.Fatal(err)
+	}
+	var sum [2]float64
+	var count [2]int
+	for i, v := range x {
+		c := 0
+		if y[i] {
+			c = 1
+		}
+		sum[c] += m.Probability(v)
+		count[c]++
+	}
+	if count[1] == 0 || sum[1]/float64(count[1]) <= sum[0]/float64(count[0]) {
+		t.Errorf("Expected flights of the planted route to score higher, got %v of %v", sum, count)
+	}
+	s, _, err := p.SolveWithOptions(time.After(time.Second), Options{Seed: 1, Model: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Validate(s); err != nil {
+		t.Errorf("Invalid route with model: %v", err)
+	}
+	if _, _, err := p.SolveWithOptions(time.After(time.Second), Options{Model: &Model{}}); err == nil {
+		t.Error("Expected error for model without features")
+	}
+	broken := func(change func(b *Model)) *Model {
+		b := *m
+		b.Mean = append([]float64(nil), m.Mean...)
+		b.Scale = append([]float64(nil), m.Scale...)
+		b.Weights = append([]float64(nil), m.Weights...)
+		change(&b)
+		return &b
+	}
+	for _, b := range []*Model{
+		broken(func(b *Model) { b.Weights[0] = math.NaN() }),
+		broken(func(b *Model) { b.Mean[1] = math.Inf(1) }),
+		broken(func(b *Model) { b.Scale[0] = 0 }),
+		broken(func(b *Model) { b.Bias = math.Inf(-1) }),
+	} {
+		if err := b.check(); err == nil {
+			t.Errorf("Expected error for model %v", b)
+		}
+	}
+}
+
+func TestDcfsWork(t *testing.T) {
+	w := newDcfsWork(1)
+	g := w.generation()
+	w.pushed()
+	if !w.wait(g) {
+		t.Error("Expected subtree pushed back to keep workers waiting")
+	}
+	g = w.generation()
+	w.finished()
+	if w.wait(g) {
+		t.Error("Expected workers to stop when no subtree is pending")
+	}
+	w = newDcfsWork(2)
+	w.stop()
+	if w.wait(w.generation()) {
+		t.Error("Expected workers to stop when one of them was stopped")
+	}
+}
+
+func TestBound(t *testing.T) {
+	b := newBound()
+	b.set(100)
+	b.set(120)
+	if b.get() != 100 {
+		t.Errorf("Expected late higher cost to leave bound at 100, got %d", b.get())
+	}
+	b.set(80)
+	if b.get() != 80 {
+		t.Errorf("Expected bound lowered to 80, got %d", b.get())
+	}
+}
+
+func TestElitePool(t *testing.T) {
+	n := 20
+	// route visiting cities in order of tos, flights listed from last day
+	route := func(tos []City, cost Money) Solution {
+		flights := make([]Flight, len(tos))
+		from := City(0)
+		for d, to := range tos {
+			flights[len(tos)-1-d] = Flight{From: from, To: to, Day: Day(d), Cost: cost / Money(len(tos))}
+			from = to
+		}
+		return Solution{flights, cost}
+	}
+	rotated := func(k int) []City {
+		tos := make([]City, n)
+		for i := 0; i < n-1; i++ {
+			tos[i] = City((i+k)%(n-1) + 1)
+		}
+		return tos
+	}
+
+	p := newElitePool(n)
+	for k := 0; k < n-1; k++ {
+		p.add(route(rotated(k), Money(1000+100*k)))
+	}
+	elite := p.get()
+	if len(elite) != poolSize {
+		t.Fatalf("Expected pool of %d solutions, got %d", poolSize, len(elite))
+	}
+	for i, e := range elite {
+		if e.totalCost != Money(1000+100*i) {
+			t.Errorf("Expected %d. elite to cost %d, got %d", i, 1000+100*i, e.totalCost)
+		}
+	}
+
+	// the same route listed in another order is similar
+	p = newElitePool(n)
+	c := rotated(0)
+	p.add(route(c, 1000))
+	sorted := route(c, 900)
+	sort.Sort(ByDay(sorted.flights))
+	if !p.add(sorted) || len(p.get()) != 1 {
+		t.Errorf("Expected cheaper similar route to replace the first one, got %v", p.get())
+	}
+	if p.add(route(c, 950)) {
+		t.Error("Expected similar route costing more to be refused")
+	}
+
+	// better route replaces all similar ones
+	p = newElitePool(n)
+	a, b := rotated(0), rotated(0)
+	a[1], a[2] = a[2], a[1]
+	b[5], b[6] = b[6], b[5]
+	p.add(route(a, 1000))
+	p.add(route(b, 1100))
+	if len(p.get()) != 2 {
+		t.Fatalf("Expected two distant routes in pool, got %d", len(p.get()))
+	}
+	if !p.add(route(c, 900)) || len(p.get()) != 1 || p.get()[0].totalCost != 900 {
+		t.Errorf("Expected route similar to both to replace them, got %v", p.get())
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	// paused gate holds engine until it is resumed, stopped one ends it
+	g := newGate()
+	g.pause()
+	passed := make(chan bool)
+	go func() {
+		defer close(passed)
+		g.wait()
+		passed <- true
+	}()
+	select {
+	case <-passed:
+		t.Fatal("Engine passed paused gate")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.resume()
+	if !<-passed {
+		t.Error("Expected engine to pass resumed gate")
+	}
+	g.pause()
+	exited := make(chan bool)
+	go func() {
+		defer close(exited)
+		g.wait()
+		exited <- true
+	}()
+	g.stop()
+	if <-exited {
+		t.Error("Expected stopped gate to end the engine")
+	}
+
+	polisher := NewPolisher(Graph{}, 1, false)
+	engines := []Engine{One{}, One{}, One{}, polisher}
+	s := newScheduler(engines, polisher, Options{})
+	s.slots = 2
+	running := func() []int {
+		var r []int
+		for i, g := range s.gates {
+			if atomic.LoadInt32(&g.paused) == 0 {
+				r = append(r, i)
+			}
+		}
+		return r
+	}
+	s.improved(1)
+	s.reschedule()
+	if len(running()) != len(engines) {
+		t.Errorf("Expected all engines to run during warmup, running %v", running())
+	}
+
+	// engines improving most get the slots, polisher is left alone
+	s.start = time.Now().Add(-time.Minute)
+	s.improved(1)
+	s.improved(2)
+	s.improved(2)
+	s.reschedule()
+	if r := running(); len(r) != 3 || r[0] != 1 || r[1] != 2 || r[2] != 3 {
+		t.Errorf("Expected engines 1, 2 and polisher to run, running %v", r)
+	}
+	// paused engine gets another chance as it ages
+	resumed := false
+	for tick := 0; tick < 100 && !resumed; tick++ {
+		s.reschedule()
+		resumed = atomic.LoadInt32(&s.gates[0].paused) == 0
+	}
+	if !resumed {
+		t.Error("Expected paused engine to be resumed by aging")
+	}
+	if r := running(); len(r) != 3 {
+		t.Errorf("Expected two engines and polisher to run, running %v", r)
+	}
+
+	// stalled engines hand slots over to busy polisher
+	for i := range s.stats {
+		s.stats[i].lastImproved = s.start
+	}
+	atomic.StoreInt32(polisher.active, 1)
+	s.reschedule()
+	if r := running(); len(r) != 2 || r[len(r)-1] != 3 {
+		t.Errorf("Expected one engine and polisher to run when stalled, running %v", r)
+	}
+	// finished engines are not scheduled any more
+	atomic.StoreInt32(polisher.active, 0)
+	s.gates[1].finish()
+	s.gates[2].finish()
+	s.reschedule()
+	if atomic.LoadInt32(&s.gates[0].paused) != 0 {
+		t.Error("Expected the only unfinished engine to run")
+	}
+	// turns skip them too, going round counts a round
+	if !s.next() || s.current != 3 || !s.next() || s.current != 0 || s.rounds != 1 {
+		t.Errorf("Expected turns of engines 3 and 0 after one round, got %d after %d", s.current, s.rounds)
+	}
+	s.stop()
+}
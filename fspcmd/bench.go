@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// instance of a benchmark, Best is price of the cheapest known route, 0 if
+// unknown, Solution is file with a route of that price if there is one
+type benchInstance struct {
+	File     string    `json:"file"`
+	Best     fsp.Money `json:"best,omitempty"`
+	Solution string    `json:"solution,omitempty"`
+}
+
+// manifest lists instances of a benchmark, relative paths are relative
+// to the manifest
+type benchManifest struct {
+	Instances []benchInstance `json:"instances"`
+}
+
+// summary of all runs of one instance, it is also the format of baseline
+type benchResult struct {
+	File   string    `json:"file"`
+	Cities int       `json:"cities"`
+	Runs   int       `json:"runs"`
+	Found  int       `json:"found"`
+	Min    fsp.Money `json:"min"`
+	Mean   float64   `json:"mean"`
+	Max    fsp.Money `json:"max"`
+	Best   fsp.Money `json:"best,omitempty"`
+	// best/mean*log2(cities), only instances with known best are scored
+	Score      float64       `json:"score"`
+	MaxScore   float64       `json:"max_score"`
+	FirstFound time.Duration `json:"first_found"`
+	BestFound  time.Duration `json:"best_found"`
+}
+
+// outcome of a single run
+type benchRun struct {
+	cost   fsp.Money
+	report fsp.Report
+	err    error
+}
+
+func benchCmd(args []string) int {
+	fs := newFlagSet("bench")
+	output := fs.String("o", "-", "Results file, - writes to stdout")
+	manifest := fs.String("manifest", "", "JSON file with instances and their best known prices")
+	timeoutSec := fs.Int("t", 10, "Maximal time in seconds for one run")
+	runs := fs.Int("runs", 3, "Number of runs for every problem")
+	seed := fs.Int64("seed", 0, "Seed of the first run, following runs use next seeds, 0 picks them based on time")
+	parallel := fs.Int("parallel", 1, "Number of runs at the same time")
+	save := fs.String("save", "", "Write results as JSON to this file, it can be used as baseline later")
+	baseline := fs.String("baseline", "", "Compare mean prices with results saved before")
+	tolerance := fs.Float64("tolerance", 1, "Mean price worse than baseline by more than this many percent is a regression")
+	paramsFile := fs.String("params", "", "JSON file with parameters of engines, like the one written by tune")
+	modelFile := fs.String("model", "", "JSON file with flight scoring model written by learn")
+	fs.Parse(args)
+	if (fs.NArg() == 0 && *manifest == "") || *runs < 1 || *parallel < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	fsp.BeVerbose = verbose
+	fsp.StartTime = time.Now()
+
+	instances, problems, err := loadInstances(*manifest, fs.Args())
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	ps, err := readParams(*paramsFile)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	model, err := readModel(*modelFile)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	timeout := time.Duration(*timeoutSec) * time.Second
+	outcomes := runBench(problems, *runs, *parallel, timeout, []fsp.Options{{Seed: *seed, Params: ps, Model: model}})[0]
+	exit := exitOK
+	results := make([]benchResult, len(instances))
+	for i, in := range instances {
+		for r, o := range outcomes[i] {
+			if o.err != nil {
+				printError(in.File+": run", r, "failed:", o.err)
+				exit = exitFailure
+			} else {
+				printInfo(in.File, "run", r, "cost", o.cost, o.report)
+			}
+		}
+		results[i] = summarize(in, problems[i].CitiesCnt(), outcomes[i])
+	}
+
+	var regressions []string
+	if *baseline != "" {
+		var old []benchResult
+		if err := readJSON(*baseline, &old); err != nil {
+			printError(err)
+			return exitFailure
+		}
+		regressions = compare(results, old, *tolerance)
+		if len(regressions) > 0 {
+			exit = exitFailure
+		}
+	}
+	if *save != "" {
+		content, err := json.MarshalIndent(results, "", " ")
+		if err == nil {
+			err = writeOutput(*save, string(content)+"\n")
+		}
+		if err != nil {
+			printError(err)
+			return exitFailure
+		}
+	}
+	if err := writeOutput(*output, benchTable(results, regressions)); err != nil {
+		printError(err)
+		return exitFailure
+	}
+	return exit
+}
+
+func readJSON(path string, v interface{}) error {
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := json.NewDecoder(in).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// instances of the manifest followed by files
+func loadInstances(manifest string, files []string) ([]benchInstance, []fsp.Problem, error) {
+	var instances []benchInstance
+	if manifest != "" {
+		m, err := readManifest(manifest)
+		if err != nil {
+			return nil, nil, err
+		}
+		instances = m.Instances
+	}
+	for _, file := range files {
+		instances = append(instances, benchInstance{File: file})
+	}
+	problems := make([]fsp.Problem, len(instances))
+	for i, in := range instances {
+		p, names, err := readProblem(in.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		if in.Solution != "" {
+			// scores are only as good as the best prices they compare with
+			if _, err := readRoute(in, p, names); err != nil {
+				return nil, nil, err
+			}
+		}
+		problems[i] = p
+	}
+	return instances, problems, nil
+}
+
+// readRoute reads solution of the instance, the route has to be valid and
+// cost the best known price
+func readRoute(in benchInstance, p fsp.Problem, names []string) (fsp.Solution, error) {
+	r, err := openInput(in.Solution)
+	if err != nil {
+		return fsp.Solution{}, err
+	}
+	defer r.Close()
+	s, err := readSolution(r, names)
+	if err != nil {
+		return s, fmt.Errorf("%s: %v", in.Solution, err)
+	}
+	if err := p.Validate(s); err != nil {
+		return s, fmt.Errorf("%s: %v", in.Solution, err)
+	}
+	if in.Best != 0 && s.GetTotalCost() != in.Best {
+		return s, fmt.Errorf("%s: route costs %d, best known price is %d", in.Solution, s.GetTotalCost(), in.Best)
+	}
+	return s, nil
+}
+
+func readManifest(path string) (benchManifest, error) {
+	var m benchManifest
+	if err := readJSON(path, &m); err != nil {
+		return m, err
+	}
+	for i, in := range m.Instances {
+		if !filepath.IsAbs(in.File) {
+			m.Instances[i].File = filepath.Join(filepath.Dir(path), in.File)
+		}
+		if in.Solution != "" && !filepath.IsAbs(in.Solution) {
+			m.Instances[i].Solution = filepath.Join(filepath.Dir(path), in.Solution)
+		}
+	}
+	return m, nil
+}
+
+// runBench solves every problem given number of times with every
+// configuration, parallel runs at the same time; run r uses seed o.Seed+r
+// unless o.Seed is 0
+func runBench(problems []fsp.Problem, runs, parallel int, timeout time.Duration, configs []fsp.Options) [][][]benchRun {
+	outcomes := make([][][]benchRun, len(configs))
+	type job struct{ config, problem, run int }
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				o := configs[j.config]
+				if o.Seed != 0 {
+					o.Seed += int64(j.run)
+				}
+				s, report, err := problems[j.problem].SolveWithOptions(time.After(timeout), o)
+				outcomes[j.config][j.problem][j.run] = benchRun{s.GetTotalCost(), report, err}
+			}
+		}()
+	}
+	for c := range configs {
+		outcomes[c] = make([][]benchRun, len(problems))
+		for p := range problems {
+			outcomes[c][p] = make([]benchRun, runs)
+		}
+	}
+	for c := range configs {
+		for p := range problems {
+			for r := 0; r < runs; r++ {
+				jobs <- job{c, p, r}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return outcomes
+}
+
+func summarize(in benchInstance, cities int, runs []benchRun) benchResult {
+	res := benchResult{File: in.File, Cities: cities, Runs: len(runs), Best: in.Best, Min: math.MaxInt32}
+	var sum float64
+	var first, best time.Duration
+	for _, r := range runs {
+		if r.err != nil {
+			continue
+		}
+		res.Found++
+		sum += float64(r.cost)
+		first += r.report.FirstFound
+		best += r.report.BestFound
+		if r.cost < res.Min {
+			res.Min = r.cost
+		}
+		if r.cost > res.Max {
+			res.Max = r.cost
+		}
+	}
+	if res.Found == 0 {
+		res.Min = 0
+		if in.Best > 0 {
+			res.MaxScore = math.Log2(float64(cities))
+		}
+		return res
+	}
+	res.Mean = sum / float64(res.Found)
+	res.FirstFound = first / time.Duration(res.Found)
+	res.BestFound = best / time.Duration(res.Found)
+	if in.Best > 0 {
+		res.MaxScore = math.Log2(float64(cities))
+		// runs without solution earn nothing
+		res.Score = float64(in.Best) / res.Mean * res.MaxScore * float64(res.Found) / float64(res.Runs)
+	}
+	return res
+}
+
+// compare finds instances that got worse than in baseline
+func compare(results, baseline []benchResult, tolerance float64) []string {
+	old := make(map[string]benchResult)
+	for _, b := range baseline {
+		old[b.File] = b
+	}
+	var regressions []string
+	for _, r := range results {
+		b, ok := old[r.File]
+		if !ok {
+			continue
+		}
+		switch {
+		case r.Found*b.Runs < b.Found*r.Runs:
+			regressions = append(regressions, fmt.Sprintf("%s: solved %d of %d runs, baseline %d of %d",
+				r.File, r.Found, r.Runs, b.Found, b.Runs))
+		case r.Found > 0 && b.Found > 0 && r.Mean > b.Mean*(1+tolerance/100):
+			regressions = append(regressions, fmt.Sprintf("%s: mean %.1f, baseline %.1f (%+.2f%%)",
+				r.File, r.Mean, b.Mean, (r.Mean/b.Mean-1)*100))
+		}
+	}
+	return regressions
+}
+
+func benchTable(results []benchResult, regressions []string) string {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%-30s %5s %8s %10s %8s %8s %7s %15s %12s %12s\n",
+		"problem", "runs", "min", "mean", "max", "best", "gap", "score", "first after", "best after")
+	var score, maxScore float64
+	for _, r := range results {
+		score += r.Score
+		maxScore += r.MaxScore
+		if r.Found == 0 {
+			fmt.Fprintf(&buffer, "%-30s %5s %8s %10s %8s %8s %7s %15s %12s %12s\n",
+				r.File, fmt.Sprintf("0/%d", r.Runs), "-", "-", "-", best(r), "-", "-", "-", "-")
+			continue
+		}
+		gap, points := "-", "-"
+		if r.Best > 0 {
+			gap = fmt.Sprintf("%.2f%%", (r.Mean/float64(r.Best)-1)*100)
+			points = fmt.Sprintf("%.3f/%.3f", r.Score, r.MaxScore)
+		}
+		fmt.Fprintf(&buffer, "%-30s %5s %8d %10.1f %8d %8s %7s %15s %12v %12v\n",
+			r.File, fmt.Sprintf("%d/%d", r.Found, r.Runs), r.Min, r.Mean, r.Max, best(r), gap, points,
+			r.FirstFound-r.FirstFound%time.Millisecond, r.BestFound-r.BestFound%time.Millisecond)
+	}
+	fmt.Fprintf(&buffer, "Score: %.3f/%.3f\n", score, maxScore)
+	for _, r := range regressions {
+		fmt.Fprintf(&buffer, "REGRESSION %s\n", r)
+	}
+	return buffer.String()
+}
+
+func best(r benchResult) string {
+	if r.Best == 0 {
+		return "-"
+	}
+	return fmt.Sprint(r.Best)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// problem exactly as given in input, including flights solver ignores
+type rawProblem struct {
+	Start   string      `json:"start"`
+	Flights []rawFlight `json:"flights"`
+}
+
+type rawFlight struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Day  int    `json:"day"`
+	Cost int    `json:"cost"`
+}
+
+func convertCmd(args []string) int {
+	fs := newFlagSet("convert")
+	input := fs.String("i", "-", "Problem file, - reads stdin")
+	output := fs.String("o", "-", "Converted problem file, - writes to stdout")
+	from := fs.String("from", "", "Input format, txt or json, guessed from file name when empty")
+	to := fs.String("to", "", "Output format, txt or json, guessed from file name when empty")
+	fs.Parse(args)
+	*from = format(*from, *input, "txt")
+	*to = format(*to, *output, "json")
+	if fs.NArg() > 0 || !validFormat(*from) || !validFormat(*to) {
+		fs.Usage()
+		return exitUsage
+	}
+
+	in, err := openInput(*input)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	defer in.Close()
+	var p rawProblem
+	if *from == "json" {
+		err = json.NewDecoder(in).Decode(&p)
+	} else {
+		p, err = readRawText(in)
+	}
+	if err != nil {
+		printError(*input+":", err)
+		return exitFailure
+	}
+	printInfo("Read", len(p.Flights), "flights")
+
+	var buffer bytes.Buffer
+	if *to == "json" {
+		enc := json.NewEncoder(&buffer)
+		enc.SetIndent("", " ")
+		err = enc.Encode(p)
+	} else {
+		writeRawText(&buffer, p)
+	}
+	if err == nil {
+		err = writeOutput(*output, buffer.String())
+	}
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// explicit format wins, otherwise file extension decides
+func format(explicit, path, fallback string) string {
+	switch {
+	case explicit != "":
+		return explicit
+	case strings.HasSuffix(path, ".json"):
+		return "json"
+	case strings.HasSuffix(path, ".txt"):
+		return "txt"
+	}
+	return fallback
+}
+
+func validFormat(f string) bool {
+	return f == "txt" || f == "json"
+}
+
+// unlike readInput this is not tuned for speed, but it checks the input
+func readRawText(r io.Reader) (rawProblem, error) {
+	var p rawProblem
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return p, fmt.Errorf("empty problem")
+	}
+	p.Start = strings.TrimSpace(scanner.Text())
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return p, fmt.Errorf("line %d: expected 'from to day cost'", line)
+		}
+		day, err := strconv.Atoi(fields[2])
+		if err != nil || day < 0 {
+			return p, fmt.Errorf("line %d: bad day %s", line, fields[2])
+		}
+		cost, err := strconv.Atoi(fields[3])
+		if err != nil || cost < 0 {
+			return p, fmt.Errorf("line %d: bad cost %s", line, fields[3])
+		}
+		p.Flights = append(p.Flights, rawFlight{fields[0], fields[1], day, cost})
+	}
+	return p, scanner.Err()
+}
+
+func writeRawText(w io.Writer, p rawProblem) {
+	fmt.Fprintln(w, p.Start)
+	for _, f := range p.Flights {
+		fmt.Fprintf(w, "%s %s %d %d\n", f.From, f.To, f.Day, f.Cost)
+	}
+}
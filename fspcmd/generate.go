@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"time"
+)
+
+// three letter names are enough for this many cities
+const maxCities = 26 * 26 * 26
+
+func generateCmd(args []string) int {
+	fs := newFlagSet("generate")
+	output := fs.String("o", "-", "Problem file, - writes to stdout")
+	n := fs.Int("n", 10, "Number of cities")
+	density := fs.Float64("density", 0.5, "Probability there is a flight between two cities on a day")
+	minCost := fs.Int("min", 10, "Minimal flight price")
+	maxCost := fs.Int("max", 500, "Maximal flight price")
+	distribution := fs.String("distribution", "uniform", "Distribution of prices, uniform, hub or seasonal")
+	hubs := fs.Int("hubs", 0, "Number of hub cities of hub distribution, 0 means tenth of cities")
+	optimal := fs.Bool("optimal", false, "Make planted route the cheapest one")
+	bottlenecks := fs.Int("bottlenecks", 0, "Number of cities with a single expensive flight to them")
+	solution := fs.String("solution", "", "Write planted route to this file")
+	seed := fs.Int64("seed", 0, "Seed for random generator, 0 picks one based on time")
+	fs.Parse(args)
+	d, err := fsp.ParseDistribution(*distribution)
+	if fs.NArg() > 0 || *n > maxCities || *minCost < 0 || err != nil {
+		fs.Usage()
+		return exitUsage
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	printInfo("Seed:", *seed)
+	problem, route, err := fsp.Generate(fsp.GeneratorOptions{
+		Cities:       *n,
+		Density:      *density,
+		MinPrice:     fsp.Money(*minCost),
+		MaxPrice:     fsp.Money(*maxCost),
+		Distribution: d,
+		Hubs:         *hubs,
+		Optimal:      *optimal,
+		Bottlenecks:  *bottlenecks,
+		Seed:         *seed,
+	})
+	if err != nil {
+		printError(err)
+		fs.Usage()
+		return exitUsage
+	}
+
+	names := make([]string, *n)
+	for i := range names {
+		names[i] = cityName(i)
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(names[0])
+	buffer.WriteString("\n")
+	for _, f := range problem.GetFlights() {
+		fmt.Fprintf(&buffer, "%s %s %d %d\n", names[f.From], names[f.To], f.Day, f.Cost)
+	}
+	if err := writeOutput(*output, buffer.String()); err != nil {
+		printError(err)
+		return exitFailure
+	}
+	if *solution != "" {
+		if err := writeOutput(*solution, printSolution(route, names)); err != nil {
+			printError(err)
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// three letter name of city, like airport codes in real data
+func cityName(i int) string {
+	return string([]byte{byte('A' + i/676), byte('A' + i/26%26), byte('A' + i%26)})
+}
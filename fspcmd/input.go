@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"github.com/Cropsey/fsp"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// openInput opens file for reading, empty path or "-" means stdin
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// writeOutput writes content to file, empty path or "-" means stdout
+func writeOutput(path, content string) error {
+	if path == "" || path == "-" {
+		_, err := io.WriteString(os.Stdout, content)
+		return err
+	}
+	return writeAtomically(path, content)
+}
+
+// readers of the file never see partially written content
+func writeAtomically(path, content string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.WriteString(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type lookup struct {
+	cityToIndex map[string]fsp.City
+	indexToCity []string
+}
+
+func getIndex(city string, l *lookup) fsp.City {
+	ci, found := l.cityToIndex[city]
+	if found {
+		return ci
+	}
+	ci = fsp.City(len(l.cityToIndex))
+	l.cityToIndex[city] = ci
+	l.indexToCity = append(l.indexToCity, city)
+	return ci
+}
+
+func readProblem(path string) (fsp.Problem, []string, error) {
+	in, err := openInput(path)
+	if err != nil {
+		return fsp.Problem{}, nil, err
+	}
+	defer in.Close()
+	return readInput(in)
+}
+
+func readInput(r io.Reader) (fsp.Problem, []string, error) {
+	lookup := &lookup{make(map[string]fsp.City), nil}
+	// number of cities is known only after all flights are read, stats
+	// are collected afterwards
+	var flights []fsp.Flight
+
+	var src string
+	stdin := bufio.NewScanner(r)
+	if stdin.Scan() {
+		src = stdin.Text()
+		getIndex(src, lookup)
+	}
+	l := make([]string, 4)
+	var i int
+	var from, to fsp.City
+	var day fsp.Day
+	var cost fsp.Money
+	for stdin.Scan() {
+		line := stdin.Text()
+		if line == "" {
+			continue
+		}
+		customSplit(line, l)
+		i, _ = strconv.Atoi(l[2])
+		day = fsp.Day(i)
+		i, _ = strconv.Atoi(l[3])
+		cost = fsp.Money(i)
+		from = getIndex(l[0], lookup)
+		to = getIndex(l[1], lookup)
+		flights = append(flights, fsp.Flight{From: from, To: to, Day: day, Cost: cost})
+	}
+	if err := stdin.Err(); err != nil {
+		return fsp.Problem{}, nil, err
+	}
+
+	n := len(lookup.indexToCity)
+	stats := fsp.CollectStats(flights, n)
+	kept := flights[:0]
+	for _, f := range flights {
+		if f.From == fsp.City(0) && f.Day != 0 {
+			// ignore any flight from src city not on the first day
+			continue
+		}
+		if f.Day == 0 && f.From != fsp.City(0) {
+			// also flights originating in different than home city are wasteful
+			continue
+		}
+		kept = append(kept, f)
+	}
+	flights = kept
+	p := fsp.NewProblem(flights, n, stats)
+	return p, lookup.indexToCity, nil
+}
+
+func customSplit(s string, r []string) {
+	/* Splits lines of input into 4 parts
+	   strictly expects format "{3}[A-Z] {3}[A-Z] \d \d"
+	   WARNING: no checks are done at all */
+	r[0] = s[:3]
+	r[1] = s[4:7]
+	pos2 := strings.LastIndexByte(s, ' ')
+	r[2] = s[8:pos2]
+	r[3] = s[pos2+1:]
+}
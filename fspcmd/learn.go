@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"time"
+)
+
+func learnCmd(args []string) int {
+	fs := newFlagSet("learn")
+	output := fs.String("o", "-", "File to write the model to, - writes to stdout")
+	manifest := fs.String("manifest", "", "JSON file with instances, their best known prices and routes")
+	timeoutSec := fs.Int("t", 10, "Time in seconds to solve instances without known route")
+	epochs := fs.Int("epochs", 500, "Number of gradient descent steps")
+	rate := fs.Float64("rate", 0.5, "Learning rate")
+	seed := fs.Int64("seed", 0, "Seed for solving instances without known route, 0 picks one based on time")
+	fs.Parse(args)
+	if (fs.NArg() == 0 && *manifest == "") || *epochs < 1 || *rate <= 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	fsp.BeVerbose = false
+	fsp.StartTime = time.Now()
+
+	var instances []benchInstance
+	if *manifest != "" {
+		m, err := readManifest(*manifest)
+		if err != nil {
+			printError(err)
+			return exitFailure
+		}
+		instances = m.Instances
+	}
+	for _, file := range fs.Args() {
+		instances = append(instances, benchInstance{File: file})
+	}
+
+	// flights of all instances, best routes label them
+	var x [][]float64
+	var y []bool
+	for _, in := range instances {
+		p, names, err := readProblem(in.File)
+		if err != nil {
+			printError(err)
+			return exitFailure
+		}
+		best, err := bestRoute(in, p, names, time.Duration(*timeoutSec)*time.Second, *seed)
+		if err != nil {
+			printError(in.File+":", err)
+			return exitFailure
+		}
+		printInfo(in.File, "best route costs", best.GetTotalCost())
+		fx, fy := fsp.TrainingExamples(p, best)
+		x = append(x, fx...)
+		y = append(y, fy...)
+	}
+	model := fsp.TrainModel(x, y, *epochs, *rate)
+	printInfo("Trained on", len(x), "flights")
+	printInfo(modelSummary(model, x, y))
+
+	content, err := json.MarshalIndent(model, "", " ")
+	if err == nil {
+		err = writeOutput(*output, string(content)+"\n")
+	}
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// bestRoute of the instance is read from its solution file, instances
+// without one are solved
+func bestRoute(in benchInstance, p fsp.Problem, names []string, timeout time.Duration, seed int64) (fsp.Solution, error) {
+	if in.Solution == "" {
+		s, _, err := p.SolveWithOptions(time.After(timeout), fsp.Options{Seed: seed})
+		return s, err
+	}
+	return readRoute(in, p, names)
+}
+
+// modelSummary tells how well model separates flights of best routes from
+// the others
+func modelSummary(m *fsp.Model, x [][]float64, y []bool) string {
+	var sum [2]float64
+	var count [2]int
+	for i, v := range x {
+		c := 0
+		if y[i] {
+			c = 1
+		}
+		sum[c] += m.Probability(v)
+		count[c]++
+	}
+	for c := range sum {
+		if count[c] > 0 {
+			sum[c] /= float64(count[c])
+		}
+	}
+	return fmt.Sprintf("Mean probability of flights of best routes %.3f, of the others %.3f", sum[1], sum[0])
+}
+
+// readModel reads model written by learn, no file means no model
+func readModel(path string) (*fsp.Model, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var m fsp.Model
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// first signal stops solving and best solution so far gets printed,
+// second one exits immediately; signals come here only until returned
+// function is called, before and after solving they just end the program
+func sigHandler(cancel chan<- struct{}) func() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	solved := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			printInfo("Signal received ", sig, ", finishing")
+			close(cancel)
+		case <-solved:
+			return
+		}
+		select {
+		case sig := <-sigs:
+			printInfo("Signal received ", sig, ", exiting")
+			os.Exit(exitFailure)
+		case <-solved:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(solved)
+	}
+}
+
+func solveCmd(args []string) int {
+	start_time := time.Now()
+	fs := newFlagSet("solve")
+	input := fs.String("i", "-", "Problem file, - reads stdin")
+	output := fs.String("o", "-", "Solution file, - writes to stdout")
+	timeoutSec := fs.Int("t", 30, "Maximal time in seconds to run")
+	stats := fs.Bool("s", false, "Just read input and write some statistics to -o, same as stats command")
+	format := fs.String("format", "text", "Format of -s statistics, text, json or csv")
+	seed := fs.Int64("seed", 0, "Seed for random generators, 0 picks one based on time")
+	deterministic := fs.Bool("deterministic", false, "Run engines one by one so the run can be replayed with the same seed")
+	rounds := fs.Int("rounds", 0, "Stop deterministic run after this many rounds instead of timeout")
+	maxNodes := fs.Uint64("nodes", 0, "Stop after engines explore this many search nodes")
+	maxSolutions := fs.Uint64("solutions", 0, "Stop after engines evaluate this many solutions")
+	stall := fs.Float64("stall", 0, "Stop when best solution does not improve for this many seconds")
+	stallNodes := fs.Uint64("stall-nodes", 0, "Stop when best solution does not improve for this many search nodes")
+	stream := fs.String("stream", "", "Write every improved solution to this file")
+	paramsFile := fs.String("params", "", "JSON file with parameters of engines, like the one written by tune")
+	modelFile := fs.String("model", "", "JSON file with flight scoring model written by learn")
+	fs.Parse(args)
+	if fs.NArg() > 0 || !statsFormat(*format) {
+		fs.Usage()
+		return exitUsage
+	}
+	fsp.BeVerbose = verbose
+	fsp.StartTime = start_time
+
+	timeout := time.After(time.Duration(*timeoutSec)*time.Second - 200*time.Millisecond)
+	problem, lookup, err := readProblem(*input)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	ps, err := readParams(*paramsFile)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	model, err := readModel(*modelFile)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	//printLookup(lookup)
+	printInfo("Input read ", problem.FlightsCnt(), " flights, after", time.Since(start_time))
+	if *stats {
+		if err := writeStats(*output, *format, problem, lookup); err != nil {
+			printError(err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	cancel := make(chan struct{})
+	options := fsp.Options{
+		Seed:          *seed,
+		Deterministic: *deterministic,
+		Rounds:        *rounds,
+		MaxNodes:      *maxNodes,
+		MaxSolutions:  *maxSolutions,
+		StallTime:     time.Duration(*stall * float64(time.Second)),
+		StallNodes:    *stallNodes,
+		Params:        ps,
+		Model:         model,
+		Cancel:        cancel,
+	}
+	if *stream != "" {
+		options.OnImprovement = func(i fsp.Improvement) {
+			err := writeAtomically(*stream, printSolution(i.Solution, lookup))
+			if err != nil {
+				printInfo("Unable to stream solution:", err)
+			}
+		}
+	}
+	stopSignals := sigHandler(cancel)
+	solution, report, err := problem.SolveWithOptions(timeout, options)
+	stopSignals()
+	if err != nil {
+		printSolveError(err, lookup)
+		return exitFailure
+	}
+	if err = writeOutput(*output, printSolution(solution, lookup)); err != nil {
+		printError(err)
+		return exitFailure
+	}
+	if verbose {
+		fmt.Fprint(os.Stderr, printVerboseSolution(solution, lookup, problem))
+	}
+	printInfo("Problem solved after", time.Since(start_time), "with total cost", solution.GetTotalCost())
+	if verbose || options.MaxNodes > 0 || options.MaxSolutions > 0 || options.StallTime > 0 || options.StallNodes > 0 {
+		fmt.Fprintln(os.Stderr, "Search", report)
+	}
+	for _, e := range report.Engines {
+		printInfo(e.Name, "nodes:", e.Nodes, "solutions:", e.Solutions)
+	}
+	return exitOK
+}
+
+// infeasible problem is described using names of the cities
+func printSolveError(err error, m []string) {
+	if e, ok := err.(*fsp.InfeasibleError); ok {
+		printError(e.Format(func(c fsp.City) string { return m[c] }))
+		return
+	}
+	printError(err)
+}
+
+func printSolution(s fsp.Solution, m []string) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(s.GetTotalCost().String())
+	buffer.WriteString("\n")
+	for _, f := range s.GetFlights() {
+		from := m[f.From]
+		to := m[f.To]
+		flight := fmt.Sprintf("%s %s %d %d\n", from, to, f.Day, f.Cost)
+		buffer.WriteString(flight)
+	}
+	return buffer.String()
+}
+
+func printVerboseSolution(s fsp.Solution, m []string, p fsp.Problem) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(s.GetTotalCost().String())
+	buffer.WriteString("\n")
+	for _, f := range s.GetFlights() {
+		from := m[f.From]
+		to := m[f.To]
+		avg := p.FlightStats().ByDest[f.From][f.To].AvgPrice
+		perc := float32(f.Cost) / avg * 100.0
+		flight := fmt.Sprintf("%s %s %3d %4d [%7.3f%% of avg %7.2f]\n", from, to, f.Day, f.Cost, perc, avg)
+		buffer.WriteString(flight)
+	}
+	return buffer.String()
+}
+
+func printLookup(m []string) {
+	for i, s := range m {
+		fmt.Fprintln(os.Stderr, i, "->", s)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"io"
+	"math"
+	"strconv"
+)
+
+func statsCmd(args []string) int {
+	fs := newFlagSet("stats")
+	input := fs.String("i", "-", "Problem file, - reads stdin")
+	output := fs.String("o", "-", "Statistics file, - writes to stdout")
+	format := fs.String("format", "text", "Output format, text, json or csv")
+	fs.Parse(args)
+	if fs.NArg() > 0 || !statsFormat(*format) {
+		fs.Usage()
+		return exitUsage
+	}
+	fsp.BeVerbose = verbose
+	problem, lookup, err := readProblem(*input)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	if err := writeStats(*output, *format, problem, lookup); err != nil {
+		printError(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// writeStats writes statistics of the problem in format to output, it is
+// shared by stats command and solve -s
+func writeStats(output, format string, problem fsp.Problem, lookup []string) error {
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case "json":
+		err = writeAnalysisJSON(&buffer, analyze(problem, lookup))
+	case "csv":
+		err = writeAnalysisCSV(&buffer, analyze(problem, lookup))
+	default:
+		writeFlightStatistics(&buffer, lookup, problem)
+	}
+	if err != nil {
+		return err
+	}
+	return writeOutput(output, buffer.String())
+}
+
+// statsFormat tells whether statistics can be written in format
+func statsFormat(format string) bool {
+	return format == "text" || format == "json" || format == "csv"
+}
+
+func writeFlightStatistics(w io.Writer, m []string, p fsp.Problem) {
+	fmt.Fprintf(w, "Common stats\n")
+	fmt.Fprintf(w, "Total flights: %d\n", p.FlightStats().TotalFlights)
+	fmt.Fprintf(w, "Avg flight price: %f\n", p.FlightStats().AvgPrice)
+
+	fmt.Fprintf(w, "Stats by destination\n")
+	for i, r := range p.FlightStats().ByDest {
+		if i >= p.CitiesCnt() {
+			break
+		}
+		var dests uint32
+		var destsDays uint32
+		var sum, cheapestCost, mostExpCost float32
+		var cheapestDest, mostExpDest fsp.City
+		cheapestCost, mostExpCost = math.MaxInt32, 0
+		for j, s := range r {
+			if s.AvgPrice != 0.0 {
+				dests++
+				destsDays += s.FlightCount
+				sum += s.AvgPrice
+				if s.AvgPrice < cheapestCost {
+					cheapestCost, cheapestDest = s.AvgPrice, fsp.City(j)
+				}
+				if s.AvgPrice > mostExpCost {
+					mostExpCost, mostExpDest = s.AvgPrice, fsp.City(j)
+				}
+			}
+		}
+		var avg float32
+		if dests > 0 {
+			avg = sum / float32(dests)
+		}
+		fmt.Fprintf(w, "%s: destinations: %3d(%4d), cheap: %s(%7.2f), expensive: %s(%7.2f), avg: %7.2f\n",
+			m[i], dests, destsDays, m[cheapestDest], cheapestCost, m[mostExpDest], mostExpCost, avg)
+	}
+
+	fmt.Fprintf(w, "\nStats by day\n")
+	for i, r := range p.FlightStats().ByDay {
+		if i >= p.CitiesCnt() {
+			break
+		}
+		var days uint32
+		var dayDests uint32
+		var sum, cheapestCost, mostExpCost float32
+		var cheapestDay, mostExpDay fsp.Day
+		cheapestCost, mostExpCost = math.MaxInt32, 0
+		for j, s := range r {
+			if s.AvgPrice != 0.0 {
+				days++
+				dayDests += s.FlightCount
+				sum += s.AvgPrice
+				if s.AvgPrice < cheapestCost {
+					cheapestCost, cheapestDay = s.AvgPrice, fsp.Day(j)
+				}
+				if s.AvgPrice > mostExpCost {
+					mostExpCost, mostExpDay = s.AvgPrice, fsp.Day(j)
+				}
+			}
+		}
+		var avg float32
+		if days > 0 {
+			avg = sum / float32(days)
+		}
+		fmt.Fprintf(w, "%s: days: %3d(%4d), cheap: %3d(%7.2f), expensive: %3d(%7.2f), avg: %7.2f\n",
+			m[i], days, dayDests, int(cheapestDay), cheapestCost, int(mostExpDay), mostExpCost, avg)
+	}
+
+	a := analyze(p, m)
+	fmt.Fprintf(w, "\nConnectivity by day\n")
+	for _, d := range a.ByDay {
+		fmt.Fprintf(w, "%3d: flights: %6d, reachable: %3d, min out-degree: %3d\n",
+			int(d.Day), d.Flights, d.Reachable, d.MinOutDegree)
+	}
+	fmt.Fprintf(w, "\nBottlenecks\n")
+	for _, b := range a.Bottlenecks {
+		fmt.Fprintf(w, "%s: %d flights %s\n", b.Name, b.Flights, b.Direction)
+	}
+}
+
+// analysis with names of the cities filled in
+func analyze(p fsp.Problem, m []string) fsp.Analysis {
+	a := p.Analyze()
+	for i := range a.ByCity {
+		a.ByCity[i].Name = m[a.ByCity[i].City]
+	}
+	for i := range a.Bottlenecks {
+		a.Bottlenecks[i].Name = m[a.Bottlenecks[i].City]
+	}
+	return a
+}
+
+func writeAnalysisJSON(w io.Writer, a fsp.Analysis) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(a)
+}
+
+// one table for everything, scope tells what the row aggregates and columns
+// not relevant for the scope are left empty
+func writeAnalysisCSV(w io.Writer, a fsp.Analysis) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"scope", "name", "flights", "min_price", "avg_price", "max_price",
+		"destinations", "days", "arrivals", "reachable", "min_out_degree", "cities", "density"})
+	prices := func(p fsp.Prices) []string {
+		return []string{strconv.Itoa(p.Flights), p.MinPrice.String(),
+			strconv.FormatFloat(p.AvgPrice, 'f', 2, 64), p.MaxPrice.String()}
+	}
+	row := func(scope, name string, p []string, rest ...string) {
+		c.Write(append(append([]string{scope, name}, p...), rest...))
+	}
+	row("global", "", prices(a.Prices), "", "", "", "", "",
+		strconv.Itoa(a.Cities), strconv.FormatFloat(a.Density, 'f', 4, 64))
+	for _, ci := range a.ByCity {
+		row("city", ci.Name, prices(ci.Prices), strconv.Itoa(ci.Destinations),
+			strconv.Itoa(ci.Days), strconv.Itoa(ci.Arrivals), "", "", "", "")
+	}
+	for _, d := range a.ByDay {
+		row("day", strconv.Itoa(int(d.Day)), prices(d.Prices), "", "", "",
+			strconv.Itoa(d.Reachable), strconv.Itoa(d.MinOutDegree), "", "")
+	}
+	for _, b := range a.Bottlenecks {
+		row("bottleneck_"+b.Direction, b.Name, []string{strconv.Itoa(b.Flights), "", "", ""},
+			"", "", "", "", "", "", "")
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// configuration of engines competing in tuning
+type tuneConfig struct {
+	params map[string]float64
+	score  float64
+}
+
+func tuneCmd(args []string) int {
+	fs := newFlagSet("tune")
+	output := fs.String("o", "-", "File to write best parameters to, - writes to stdout")
+	manifest := fs.String("manifest", "", "JSON file with instances and their best known prices")
+	timeoutSec := fs.Float64("t", 2, "Maximal time in seconds for one run")
+	configs := fs.Int("configs", 16, "Number of random configurations to start with, defaults are added to them")
+	runs := fs.Int("runs", 1, "Number of runs for every problem in the first round, it grows with every round")
+	eta := fs.Int("eta", 2, "Only 1/eta best configurations advance to the next round")
+	seed := fs.Int64("seed", 0, "Seed for random configurations and runs, 0 picks one based on time")
+	parallel := fs.Int("parallel", 1, "Number of runs at the same time")
+	fs.Parse(args)
+	if (fs.NArg() == 0 && *manifest == "") || *configs < 1 || *runs < 1 || *eta < 2 || *parallel < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	fsp.BeVerbose = false // engines running in parallel would only make noise
+	fsp.StartTime = time.Now()
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	printInfo("Seed:", *seed)
+
+	instances, problems, err := loadInstances(*manifest, fs.Args())
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	alive := []*tuneConfig{{params: map[string]float64{}}}
+	for i := 0; i < *configs; i++ {
+		alive = append(alive, &tuneConfig{params: randomParams(rng)})
+	}
+
+	// successive halving, every round gives the survivors eta times more
+	// runs, all configurations use the same seeds in a round
+	timeout := time.Duration(*timeoutSec * float64(time.Second))
+	for round := 0; ; round++ {
+		printInfo("Round", round, "with", len(alive), "configurations and", *runs, "runs")
+		options := make([]fsp.Options, len(alive))
+		for i, c := range alive {
+			options[i] = fsp.Options{Seed: *seed + int64(round)*1000, Params: c.params}
+		}
+		outcomes := runBench(problems, *runs, *parallel, timeout, options)
+		scoreConfigs(alive, instances, problems, outcomes)
+		sort.SliceStable(alive, func(i, j int) bool { return alive[i].score > alive[j].score })
+		for _, c := range alive {
+			printInfo(fmt.Sprintf("%8.3f", c.score), formatParams(c.params))
+		}
+		if len(alive) == 1 {
+			break
+		}
+		alive = alive[:(len(alive)+*eta-1) / *eta]
+		*runs *= *eta
+	}
+
+	content, err := json.MarshalIndent(alive[0].params, "", " ")
+	if err == nil {
+		err = writeOutput(*output, string(content)+"\n")
+	}
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// random value of every tunable parameter within its range
+func randomParams(rng *rand.Rand) map[string]float64 {
+	params := make(map[string]float64)
+	for _, t := range fsp.Tunables {
+		v := t.Min + rng.Float64()*(t.Max-t.Min)
+		if t.Integer {
+			v = math.Floor(v + 0.5)
+		} else {
+			v = math.Floor(v*1000+0.5) / 1000
+		}
+		params[t.Name] = v
+	}
+	return params
+}
+
+// scoreConfigs sums bench scores of configurations over all instances,
+// cheapest price any configuration found is the best price of instances
+// without known one
+func scoreConfigs(configs []*tuneConfig, instances []benchInstance, problems []fsp.Problem, outcomes [][][]benchRun) {
+	for _, config := range configs {
+		config.score = 0
+	}
+	for p, in := range instances {
+		if in.Best == 0 {
+			in.Best = math.MaxInt32
+			for c := range configs {
+				for _, r := range outcomes[c][p] {
+					if r.err == nil && r.cost < in.Best {
+						in.Best = r.cost
+					}
+				}
+			}
+			if in.Best == math.MaxInt32 {
+				continue
+			}
+		}
+		for c, config := range configs {
+			config.score += summarize(in, problems[p].CitiesCnt(), outcomes[c][p]).Score
+		}
+	}
+}
+
+func formatParams(params map[string]float64) string {
+	if len(params) == 0 {
+		return "defaults"
+	}
+	content, _ := json.Marshal(params)
+	return string(content)
+}
+
+// readParams reads parameters of engines written by tune, no file means
+// no parameters
+func readParams(path string) (map[string]float64, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var params map[string]float64
+	if err := readJSON(path, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
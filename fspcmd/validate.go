@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Cropsey/fsp"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func validateCmd(args []string) int {
+	fs := newFlagSet("validate")
+	input := fs.String("i", "-", "Problem file, - reads stdin")
+	solutionFile := fs.String("solution", "", "Solution file, - reads stdin")
+	fs.Parse(args)
+	if fs.NArg() > 0 || *solutionFile == "" || (*input == "-" && *solutionFile == "-") {
+		fs.Usage()
+		return exitUsage
+	}
+	fsp.BeVerbose = verbose
+	problem, names, err := readProblem(*input)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	in, err := openInput(*solutionFile)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	defer in.Close()
+	solution, err := readSolution(in, names)
+	if err != nil {
+		printError(*solutionFile+":", err)
+		return exitFailure
+	}
+	if err = problem.Validate(solution); err != nil {
+		if e, ok := err.(*fsp.ValidationError); ok && e.Day >= 0 {
+			printError(fmt.Sprintf("invalid solution, day %d, flight %s -> %s: %s", e.Day, names[e.From], names[e.To], e.Reason))
+		} else {
+			printError("invalid solution,", err)
+		}
+		return exitFailure
+	}
+	fmt.Println("valid, total cost", solution.GetTotalCost())
+	return exitOK
+}
+
+// reads solution in the format we print it, total cost on the first line
+// has to match the flights
+func readSolution(r io.Reader, names []string) (fsp.Solution, error) {
+	cities := make(map[string]fsp.City, len(names))
+	for i, n := range names {
+		cities[n] = fsp.City(i)
+	}
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return fsp.Solution{}, fmt.Errorf("empty solution")
+	}
+	total, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		return fsp.Solution{}, fmt.Errorf("bad total cost: %v", err)
+	}
+	flights := make([]fsp.Flight, 0, len(names))
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return fsp.Solution{}, fmt.Errorf("line %d: expected 'from to day cost'", line)
+		}
+		from, ok := cities[fields[0]]
+		if !ok {
+			return fsp.Solution{}, fmt.Errorf("line %d: unknown city %s", line, fields[0])
+		}
+		to, ok := cities[fields[1]]
+		if !ok {
+			return fsp.Solution{}, fmt.Errorf("line %d: unknown city %s", line, fields[1])
+		}
+		day, err := strconv.Atoi(fields[2])
+		if err != nil || day < 0 {
+			return fsp.Solution{}, fmt.Errorf("line %d: bad day %s", line, fields[2])
+		}
+		cost, err := strconv.Atoi(fields[3])
+		if err != nil || cost < 0 {
+			return fsp.Solution{}, fmt.Errorf("line %d: bad cost %s", line, fields[3])
+		}
+		flights = append(flights, fsp.Flight{From: from, To: to, Day: fsp.Day(day), Cost: fsp.Money(cost)})
+	}
+	if err := scanner.Err(); err != nil {
+		return fsp.Solution{}, err
+	}
+	s := fsp.NewSolution(flights)
+	if s.GetTotalCost() != fsp.Money(total) {
+		return s, fmt.Errorf("total cost %d, flights cost %d", total, s.GetTotalCost())
+	}
+	return s, nil
+}
@@ -0,0 +1,168 @@
+package fsp
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Distribution of flight prices in generated problem
+type Distribution int
+
+const (
+	// prices spread evenly between minimal and maximal price
+	Uniform Distribution = iota
+	// flights from or to hub cities are cheap, flights between two other
+	// cities are expensive and less frequent
+	HubAndSpoke
+	// prices rise towards the middle of the trip and fall again
+	Seasonal
+)
+
+var distributionNames = []string{"uniform", "hub", "seasonal"}
+
+func (d Distribution) String() string {
+	if int(d) < len(distributionNames) {
+		return distributionNames[d]
+	}
+	return fmt.Sprintf("Distribution(%d)", int(d))
+}
+
+// ParseDistribution is inverse of String
+func ParseDistribution(s string) (Distribution, error) {
+	for i, name := range distributionNames {
+		if name == s {
+			return Distribution(i), nil
+		}
+	}
+	return Uniform, fmt.Errorf("unknown distribution %q", s)
+}
+
+// GeneratorOptions describes problem Generate creates
+type GeneratorOptions struct {
+	Cities int
+	// probability there is a flight between two cities on a day
+	Density      float64
+	MinPrice     Money
+	MaxPrice     Money
+	Distribution Distribution
+	// number of hub cities of HubAndSpoke, tenth of cities when 0
+	Hubs int
+	// make planted route the only cheapest one, otherwise it just
+	// guarantees there is some route
+	Optimal bool
+	// number of cities with a single expensive flight to them, like
+	// in data/bottleneck_15.txt
+	Bottlenecks int
+	Seed        int64
+}
+
+// bottleneck flight costs this many times maximal price
+const bottleneckPrice = 10
+
+// Generate creates random problem with a route planted in it, city 0 is
+// the start
+//
+// when Optimal is set every other flight to a city costs more than the
+// flight of the route to that city, as every route arrives to every city
+// exactly once, planted route is the cheapest
+func Generate(o GeneratorOptions) (Problem, Solution, error) {
+	n := o.Cities
+	switch {
+	case n < 2:
+		return Problem{}, Solution{}, fmt.Errorf("at least 2 cities needed, got %d", n)
+	case o.Density < 0 || o.Density > 1:
+		return Problem{}, Solution{}, fmt.Errorf("density %v out of range [0, 1]", o.Density)
+	case o.MaxPrice < o.MinPrice || o.Optimal && o.MaxPrice == o.MinPrice:
+		return Problem{}, Solution{}, fmt.Errorf("bad price range [%d, %d]", o.MinPrice, o.MaxPrice)
+	case o.Bottlenecks < 0 || o.Bottlenecks > n-1:
+		return Problem{}, Solution{}, fmt.Errorf("%d bottlenecks with %d cities", o.Bottlenecks, n)
+	case o.Hubs < 0:
+		return Problem{}, Solution{}, fmt.Errorf("negative number of hubs %d", o.Hubs)
+	}
+	rng := rand.New(rand.NewSource(o.Seed))
+
+	// route[d] is the city we are in at the start of day d
+	route := make([]City, n+1)
+	for i, c := range rng.Perm(n - 1) {
+		route[i+1] = City(c + 1)
+	}
+	bottleneck := make([]bool, n)
+	for _, c := range rng.Perm(n - 1)[:o.Bottlenecks] {
+		bottleneck[c+1] = true
+	}
+	hubs := o.Hubs
+	if hubs == 0 {
+		hubs = (n + 9) / 10
+	}
+	hub := make([]bool, n)
+	for _, c := range rng.Perm(n)[:min(hubs, n)] {
+		hub[c] = true
+	}
+
+	span := int(o.MaxPrice - o.MinPrice)
+	price := func(from, to City, day Day) Money {
+		switch o.Distribution {
+		case HubAndSpoke:
+			third := span / 3
+			if hub[from] || hub[to] {
+				return o.MinPrice + Money(rng.Intn(third+1))
+			}
+			return o.MinPrice + Money(third+rng.Intn(span-third+1))
+		case Seasonal:
+			season := (1 - math.Cos(2*math.Pi*float64(day)/float64(n))) / 2
+			half := span / 2
+			low := o.MinPrice + Money(season*float64(span-half))
+			return low + Money(rng.Intn(half+1))
+		}
+		return o.MinPrice + Money(rng.Intn(span+1))
+	}
+
+	// price of the route flight to the city
+	planted := make([]Money, n)
+	for day := 0; day < n; day++ {
+		to := route[day+1]
+		planted[to] = price(route[day], to, Day(day))
+		if o.Optimal && planted[to] == o.MaxPrice {
+			planted[to]--
+		}
+		if bottleneck[to] {
+			planted[to] = bottleneckPrice * o.MaxPrice
+		}
+	}
+
+	var flights []Flight
+	solution := make([]Flight, 0, n)
+	for day := 0; day < n; day++ {
+		for from := City(0); int(from) < n; from++ {
+			if (day == 0) != (from == 0) {
+				// nobody would use these flights
+				continue
+			}
+			for to := City(0); int(to) < n; to++ {
+				if to == from || (to == 0) != (day == n-1) {
+					continue
+				}
+				if from == route[day] && to == route[day+1] {
+					f := Flight{from, to, Day(day), planted[to], 0, 0.0}
+					flights = append(flights, f)
+					solution = append(solution, f)
+					continue
+				}
+				density := o.Density
+				if o.Distribution == HubAndSpoke && !hub[from] && !hub[to] {
+					density /= 2
+				}
+				if bottleneck[to] || rng.Float64() >= density {
+					continue
+				}
+				cost := price(from, to, Day(day))
+				if o.Optimal && cost <= planted[to] {
+					cost = planted[to] + 1
+				}
+				flights = append(flights, Flight{from, to, Day(day), cost, 0, 0.0})
+			}
+		}
+	}
+	return NewProblem(flights, n, CollectStats(flights, n)), NewSolution(solution), nil
+}
@@ -1,132 +1,223 @@
 package fsp
 
-import "sort"
+import (
+	"fmt"
+	"sort"
+)
 
+// problems up to this many (from, day, to) combinations get dense lookup
+// table, bigger ones hashed, 4 MB of it is about 100 cities
+const denseLookup = 1 << 20
+
+// Graph keeps flights that can be part of a route packed in a single slice,
+// indexes are offsets into it
 type Graph struct {
-	data              [][][]*Flight
-	fromDaySortedCost [][][]*Flight
-	dayFromData       [][][]*Flight
-	fromDayTo         [][][]*Flight
-	toDayData         [][][]*Flight
-	antsGraph         [][][]FlightIndex
-	source            City
-	size              int
+	// sorted by departure city, day and cost, flights from city c on day d
+	// are flights[from[c*size+d]:from[c*size+d+1]]
+	flights []Flight
+	from    []int32
+	// indexes of flights sorted by destination and day, flights to city c
+	// on day d are to[toStart[c*size+d]:toStart[c*size+d+1]]
+	to      []int32
+	toStart []int32
+	// index of flight by departure city, day and destination, -1 if none
+	dense  []int32
+	hashed map[int]int32
+	source City
+	size   int
+	// cities pruning found have to be visited on the same day, nil if none
+	conflict *Infeasibility
 }
 
 func NewGraph(problem Problem) Graph {
-	graph := new(Graph)
-	graph.source = problem.start
-	graph.size = problem.n
-	filter(problem, graph)
-	return *graph
+	g := Graph{source: problem.start, size: problem.n}
+	keep, conflict := prune(problem, g.size, g.source)
+	g.conflict = conflict
+	g.pack(problem.flights, keep)
+	printInfo(fmt.Sprintf("Graph keeps %d of %d flights, %.1f%% pruned",
+		len(g.flights), len(problem.flights), g.pruned(len(problem.flights))*100))
+	return g
 }
 
-type byCost []*Flight
+// unprunedGraph keeps every flight that can be part of some route judging
+// by the flight alone, it describes the problem as it was given
+func unprunedGraph(problem Problem) Graph {
+	g := Graph{source: problem.start, size: problem.n}
+	g.pack(problem.flights, newFlightBuckets(problem, g.size, g.source).keep())
+	return g
+}
 
-func (g Graph) get(from City, day Day, to City) *Flight {
-	if g.fromDayTo[from] == nil {
-		return nil
+// pack kept flights and build indexes, all of them are counting sorts
+// so that it is linear in number of flights
+func (g *Graph) pack(flights []Flight, keep []bool) {
+	n := g.size
+	buckets := n * n
+	g.from = make([]int32, buckets+1)
+	for i := range flights {
+		if keep[i] {
+			g.from[int(flights[i].From)*n+int(flights[i].Day)+1]++
+		}
 	}
-	if g.fromDayTo[from][day] == nil {
-		return nil
+	for b := 0; b < buckets; b++ {
+		g.from[b+1] += g.from[b]
+	}
+	g.flights = make([]Flight, g.from[buckets])
+	next := make([]int32, buckets)
+	copy(next, g.from)
+	for i := range flights {
+		if keep[i] {
+			b := int(flights[i].From)*n + int(flights[i].Day)
+			g.flights[next[b]] = flights[i]
+			// marks of the input are not carried into the graph
+			g.flights[next[b]].Heuristic = 0
+			g.flights[next[b]].Penalty = 0
+			next[b]++
+		}
+	}
+	for b := 0; b < buckets; b++ {
+		// stable, flights of the same price stay in input order
+		sort.Stable(byCost(g.flights[g.from[b]:g.from[b+1]]))
 	}
-	return g.fromDayTo[from][day][to]
-}
 
-//type byCost []Flight
+	g.toStart = make([]int32, buckets+1)
+	for _, f := range g.flights {
+		g.toStart[int(f.To)*n+int(f.Day)+1]++
+	}
+	for b := 0; b < buckets; b++ {
+		g.toStart[b+1] += g.toStart[b]
+	}
+	g.to = make([]int32, len(g.flights))
+	copy(next, g.toStart)
+	for i, f := range g.flights {
+		b := int(f.To)*n + int(f.Day)
+		g.to[next[b]] = int32(i)
+		next[b]++
+	}
 
-func (f byCost) Len() int {
-	return len(f)
-}
-func (f byCost) Swap(i, j int) {
-	f[i], f[j] = f[j], f[i]
+	if n*n*n <= denseLookup {
+		g.dense = make([]int32, n*n*n)
+		for i := range g.dense {
+			g.dense[i] = -1
+		}
+	} else {
+		g.hashed = make(map[int]int32, len(g.flights))
+	}
+	for i, f := range g.flights {
+		k := g.key(f.From, f.Day, f.To)
+		if g.dense != nil {
+			g.dense[k] = int32(i)
+		} else {
+			g.hashed[k] = int32(i)
+		}
+	}
 }
-func (f byCost) Less(i, j int) bool {
-	return f[i].Cost < f[j].Cost
+
+func (g Graph) key(from City, day Day, to City) int {
+	return (int(from)*g.size+int(day))*g.size + int(to)
 }
 
-func set(slice [][][]*Flight, from City, day Day, flight *Flight) {
-	if slice[from] == nil {
-		slice[from] = make([][]*Flight, MAX_CITIES)
-	}
-	if slice[from][day] == nil {
-		slice[from][day] = make([]*Flight, 0, MAX_CITIES)
+// flights from city on day, cheapest first
+func (g Graph) fromDay(from City, day Day) []Flight {
+	if int(from) >= g.size || int(day) >= g.size {
+		return nil
 	}
-	slice[from][day] = append(slice[from][day], flight)
+	b := int(from)*g.size + int(day)
+	return g.flights[g.from[b]:g.from[b+1]]
 }
-func seta(slice [][][]FlightIndex, from City, day Day, fi FlightIndex) {
-	if slice[from] == nil {
-		slice[from] = make([][]FlightIndex, MAX_CITIES)
-	}
-	if slice[from][day] == nil {
-		slice[from][day] = make([]FlightIndex, 0, MAX_CITIES)
+
+// index of the first flight of fromDay, the others follow
+func (g Graph) fromDayIndex(from City, day Day) FlightIndex {
+	return FlightIndex(g.from[int(from)*g.size+int(day)])
+}
+
+// indexes of flights to city on day
+func (g Graph) toDay(to City, day Day) []int32 {
+	if int(to) >= g.size || int(day) >= g.size {
+		return nil
 	}
-	slice[from][day] = append(slice[from][day], fi)
+	b := int(to)*g.size + int(day)
+	return g.to[g.toStart[b]:g.toStart[b+1]]
 }
-func setcc(slice [][][]*Flight, c1 City, day Day, c2 City, flight Flight) {
-	if slice[c1] == nil {
-		slice[c1] = make([][]*Flight, MAX_CITIES)
+
+// index of flight from city on day to another city, -1 if there is none
+func (g Graph) index(from City, day Day, to City) int {
+	if int(from) >= g.size || int(day) >= g.size || int(to) >= g.size {
+		return -1
+	}
+	k := g.key(from, day, to)
+	if g.dense != nil {
+		return int(g.dense[k])
 	}
-	if slice[c1][day] == nil {
-		slice[c1][day] = make([]*Flight, MAX_CITIES)
+	if i, ok := g.hashed[k]; ok {
+		return int(i)
 	}
-	slice[c1][day][c2] = &flight
+	return -1
 }
 
-func setDayCity(slice [][][]*Flight, day Day, city City, flight *Flight) {
-	if slice[day] == nil {
-		slice[day] = make([][]*Flight, MAX_CITIES)
-	}
-	if slice[day][city] == nil {
-		slice[day][city] = make([]*Flight, 0, MAX_CITIES)
+func (g Graph) get(from City, day Day, to City) *Flight {
+	i := g.index(from, day, to)
+	if i < 0 {
+		return nil
 	}
-	slice[day][city] = append(slice[day][city], flight)
-	//printInfo("appending", flight, "to [", day, city, "]")
+	return &g.flights[i]
 }
 
-func filter(p Problem, graph *Graph) {
-	g := make([][][]*Flight, MAX_CITIES)
-	fdsc := make([][][]*Flight, MAX_CITIES)
-	dtf := make([][][]*Flight, MAX_CITIES)
-	fdt := make([][][]*Flight, MAX_CITIES)
-	tdf := make([][][]*Flight, MAX_CITIES)
-	ants := make([][][]FlightIndex, MAX_CITIES)
-	lastDay := Day(graph.size - 1)
-	for i, _ := range p.flights {
-		if p.flights[i].To == 0 && p.flights[i].Day != lastDay {
-			// no need to append paths to home city before last day
-			continue
-		}
-		if p.flights[i].To != 0 && p.flights[i].Day == lastDay {
-			// no need to append paths to another city on last day
-			continue
-		}
-		set(g, p.flights[i].From, p.flights[i].Day, &p.flights[i])
-		set(fdsc, p.flights[i].From, p.flights[i].Day, &p.flights[i])
-		seta(ants, p.flights[i].From, p.flights[i].Day, FlightIndex(i))
-		setDayCity(dtf, p.flights[i].Day, p.flights[i].From, &p.flights[i])
-		setcc(fdt, p.flights[i].From, p.flights[i].Day, p.flights[i].To, p.flights[i])
-		set(tdf, p.flights[i].To, p.flights[i].Day, &p.flights[i])
-	}
-	for _, dayList := range fdsc {
-		for _, flightList := range dayList {
-			sort.Sort(byCost(flightList))
+// reachable tells for every day in which cities we can be after the flight
+// of that day, which cities were visited before is not taken into account
+func (g Graph) reachable() [][]bool {
+	reach := make([][]bool, g.size)
+	current := []City{g.source}
+	for day := 0; day < g.size; day++ {
+		reach[day] = make([]bool, g.size)
+		next := make([]City, 0, g.size)
+		for _, from := range current {
+			for _, f := range g.fromDay(from, Day(day)) {
+				if !reach[day][f.To] {
+					reach[day][f.To] = true
+					next = append(next, f.To)
+				}
+			}
 		}
+		current = next
 	}
-	graph.data = g
-	graph.fromDaySortedCost = fdsc
-	graph.antsGraph = ants
-	graph.dayFromData = dtf
-
-	/*
-		for i, x := range g {
-			for j, y := range x {
-				for k, z := range y {
-					printInfo("[", i, j, k, "]:", *z)
-					}
+	return reach
+}
+
+// returning tells for every day from which cities we can get back to
+// source by the last day when we are there at the start of the day, which
+// cities were visited before is not taken into account
+func (g Graph) returning() [][]bool {
+	ret := make([][]bool, g.size)
+	for day := g.size - 1; day >= 0; day-- {
+		ret[day] = make([]bool, g.size)
+		for from := range ret[day] {
+			for _, f := range g.fromDay(City(from), Day(day)) {
+				if day == g.size-1 && f.To == g.source || day < g.size-1 && ret[day+1][f.To] {
+					ret[day][from] = true
+					break
 				}
 			}
-	*/
-	graph.fromDayTo = fdt
-	graph.toDayData = tdf
+		}
+	}
+	return ret
+}
+
+// share of flights pruned
+func (g Graph) pruned(flights int) float64 {
+	if flights == 0 {
+		return 0
+	}
+	return 1 - float64(len(g.flights))/float64(flights)
+}
+
+type byCost []Flight
+
+func (f byCost) Len() int {
+	return len(f)
+}
+func (f byCost) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+func (f byCost) Less(i, j int) bool {
+	return f[i].Cost < f[j].Cost
 }
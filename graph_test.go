@@ -1,6 +1,8 @@
 package fsp
 
-/*import "testing"
+import "testing"
+
+/*
 
 func check(problem Problem, expected []Flight, t *testing.T) {
 	graph := NewGraph(problem)
@@ -100,3 +102,12 @@ func TestMultipleFiler(t *testing.T) {
 	}
 	check(problem, expect, t)
 }*/
+
+func BenchmarkNewGraph(b *testing.B) {
+	p := randomProblem(100, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewGraph(p)
+	}
+}
@@ -9,14 +9,15 @@ import (
 type Greedy struct {
 	graph       Graph
 	currentBest Money
+	seed        int64
 }
 
 func (d Greedy) Name() string {
 	return "Greedy"
 }
 
-func NewGreedy(g Graph) Greedy {
-	return Greedy{graph, Money(math.MaxInt32)}
+func NewGreedy(g Graph, seed int64) Greedy {
+	return Greedy{g, Money(math.MaxInt32), seed}
 }
 
 func (d Greedy) Solve(comm comm, problem Problem) {
@@ -25,11 +26,19 @@ func (d Greedy) Solve(comm comm, problem Problem) {
 		visited := make(map[City]bool)
 		partial := partial{visited, flights, problem.n, 0}
 
-		dst := d.graph.fromDaySortedCost[0][0]
-		for _, f := range dst {
-			partial.fly(f)
-			d.dfs(comm, &partial)
-			partial.backtrack()
+		// runs grow until one of them searches the whole tree
+		r := newRestarts(geometricSchedule(restartUnit, restartGrowth), d.seed, newNogoods(problem.n, d.seed))
+		for cut := true; cut; r.next() {
+			cut = false
+			dst := d.graph.fromDay(0, 0)
+			for _, i := range r.flightOrder(dst) {
+				partial.fly(&dst[i])
+				cut = d.dfs(comm, &partial, r, r.start(dst[i].From))
+				partial.backtrack()
+				if cut {
+					break
+				}
+			}
 		}
 		comm.done()
 	} else {
@@ -89,9 +98,14 @@ func (p *partial) backtrack() {
 	p.cost -= f.Cost
 }
 
-func (d *Greedy) dfs(comm comm, partial *partial) {
+// dfs returns true when restart cut the search
+func (d *Greedy) dfs(comm comm, partial *partial, r *restarts, key nogoodKey) bool {
+	comm.yield()
+	if r.step() {
+		return true
+	}
 	if partial.cost > d.currentBest {
-		return
+		return false
 	}
 	if partial.roundtrip() {
 		d.currentBest = comm.sendSolution(NewSolution(partial.solution()))
@@ -99,13 +113,23 @@ func (d *Greedy) dfs(comm comm, partial *partial) {
 
 	lf := partial.lastFlight()
 	if partial.hasVisited(lf.To) {
-		return
+		return false
 	}
 
-	dst := d.graph.fromDaySortedCost[lf.To][int(lf.Day+1)%d.graph.size]
-	for _, f := range dst {
-		partial.fly(f)
-		d.dfs(comm, partial)
+	day := Day(int(lf.Day+1) % d.graph.size)
+	state := r.state(key, lf.To, day)
+	if r.failed(state, partial.cost) {
+		return false
+	}
+	dst := d.graph.fromDay(lf.To, day)
+	for _, i := range r.flightOrder(dst) {
+		partial.fly(&dst[i])
+		cut := d.dfs(comm, partial, r, r.visit(key, lf.To))
 		partial.backtrack()
+		if cut {
+			return true
+		}
 	}
+	r.fail(state, partial.cost)
+	return false
 }
@@ -4,41 +4,40 @@ import (
 	"container/heap"
 	"math"
 	//	"sort"
-	"time"
 )
 
+// GreedyRounds searches from flights with the best discounts in turns,
+// each of them for a run of Luby restart schedule
 type GreedyRounds struct {
 	graph       Graph
 	currentBest Money
+	seed        int64
 }
 
 func (d GreedyRounds) Name() string {
 	return "GreedyRounds"
 }
 
-func NewGreedyRounds(g Graph) GreedyRounds {
-	return GreedyRounds{graph, Money(math.MaxInt32)}
+func NewGreedyRounds(g Graph, seed int64) GreedyRounds {
+	return GreedyRounds{g, Money(math.MaxInt32), seed}
 }
 
 func initStart(g Graph, problem Problem) []fd {
 	h := fdHeap(make([]fd, 0, 10))
 
-	for _, fromList := range g.data {
-		for _, flights := range fromList {
-			for _, f := range flights {
-				stat := problem.stats.ByDest[f.From][f.To]
-				discount := stat.AvgPrice - float32(f.Cost)
-				if len(h) < cap(h) {
-					h = append(h, fd{f, discount})
-					if len(h) == cap(h) {
-						heap.Init(&h)
-					}
-				} else {
-					if h[0].d < discount {
-						heap.Pop(&h)
-						heap.Push(&h, fd{f, discount})
-					}
-				}
+	for i := range g.flights {
+		f := &g.flights[i]
+		stat := problem.stats.ByDest[f.From][f.To]
+		discount := stat.AvgPrice - float32(f.Cost)
+		if len(h) < cap(h) {
+			h = append(h, fd{f, discount})
+			if len(h) == cap(h) {
+				heap.Init(&h)
+			}
+		} else {
+			if h[0].d < discount {
+				heap.Pop(&h)
+				heap.Push(&h, fd{f, discount})
 			}
 		}
 	}
@@ -50,16 +49,33 @@ func (d GreedyRounds) Solve(comm comm, problem Problem) {
 	visited := make(map[City]bool)
 	partial := partial{visited, flights, problem.n, 0}
 
-	for i, f := range initStart(d.graph, problem) {
-		printInfo("GreedyRounds start", i, f)
-		partial.fly(f.f)
-		d.dfs(comm, &partial, time.After(3*time.Second))
+	starts := initStart(d.graph, problem)
+	done := make([]bool, len(starts))
+	left := len(starts)
+	r := newRestarts(lubySchedule(restartUnit), d.seed, newNogoods(problem.n, d.seed))
+	for run := 0; left > 0; run++ {
+		i := run % len(starts)
+		if done[i] {
+			continue
+		}
+		// every start gets its first run in original order
+		r.begin(run / len(starts))
+		f := starts[i].f
+		partial.fly(f)
+		cut := d.dfs(comm, &partial, r, r.start(f.From))
 		partial.backtrack()
+		if !cut {
+			printInfo("GreedyRounds searched whole tree of start", i, f)
+			done[i] = true
+			left--
+		}
 	}
 }
 
-func (d *GreedyRounds) dfs(comm comm, partial *partial, timeout <-chan time.Time) bool {
-	if expired(timeout) {
+// dfs returns true when restart cut the search
+func (d *GreedyRounds) dfs(comm comm, partial *partial, r *restarts, key nogoodKey) bool {
+	comm.yield()
+	if r.step() {
 		return true
 	}
 	if partial.cost > d.currentBest {
@@ -74,15 +90,21 @@ func (d *GreedyRounds) dfs(comm comm, partial *partial, timeout <-chan time.Time
 		return false
 	}
 
-	dst := d.graph.fromDaySortedCost[lf.To][int(lf.Day+1)%d.graph.size]
-	for _, f := range dst {
-		partial.fly(f)
-		expired := d.dfs(comm, partial, timeout)
+	day := Day(int(lf.Day+1) % d.graph.size)
+	state := r.state(key, lf.To, day)
+	if r.failed(state, partial.cost) {
+		return false
+	}
+	dst := d.graph.fromDay(lf.To, day)
+	for _, i := range r.flightOrder(dst) {
+		partial.fly(&dst[i])
+		cut := d.dfs(comm, partial, r, r.visit(key, lf.To))
 		partial.backtrack()
-		if expired {
+		if cut {
 			return true
 		}
 	}
+	r.fail(state, partial.cost)
 	return false
 }
 
@@ -0,0 +1,231 @@
+package fsp
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// longest chain of exchanges tried from one position
+const lkMaxDepth = 50
+
+// local search of one tour is given this long
+const lkTime = 5 * time.Second
+
+// exchanges of random cities kicking a tour already optimized
+const lkKicks = 3
+
+// Lin-Kernighan style engine, it improves tours of the elite pool by
+// chains of city exchanges, every exchange starts at the position the
+// previous one left a bad flight at; chain is applied once its cumulative
+// gain is positive even if some steps on the way made the tour worse
+type Lk struct {
+	graph   Graph
+	rng     *rand.Rand
+	penalty int64
+}
+
+func NewLk(graph Graph, seed int64) Lk {
+	var max Money
+	for _, f := range graph.flights {
+		if f.Cost > max {
+			max = f.Cost
+		}
+	}
+	// missing flight costs more than any flight
+	return Lk{graph, rand.New(rand.NewSource(seed)), int64(max) + 1}
+}
+
+func (e Lk) Name() string {
+	return "Lk"
+}
+
+func (e Lk) Solve(comm comm, p Problem) {
+	if e.graph.size < 4 {
+		return
+	}
+	optimized := make(map[uint64]bool)
+	for {
+		comm.yield()
+		elite := comm.elites()
+		if len(elite) == 0 {
+			// wait for other engines to fill the pool
+			comm.idle()
+			continue
+		}
+		var t *lkTour
+		for _, s := range elite {
+			if hash := tourHash(s.flights); !optimized[hash] {
+				optimized[hash] = true
+				t = newLkTour(e.graph, s.flights, e.penalty)
+				break
+			}
+		}
+		if t == nil {
+			// all of them are local optima already, kick one out, chains
+			// repair flights the kick made missing
+			t = newLkTour(e.graph, elite[e.rng.Intn(len(elite))].flights, e.penalty)
+			for k := 0; k < lkKicks; k++ {
+				t.swap(e.rng.Intn(e.graph.size-1)+1, e.rng.Intn(e.graph.size-1)+1)
+			}
+		}
+		t.optimize(comm, e.rng, comm.newLimit(lkTime))
+		if t.missing == 0 {
+			comm.sendSolution(NewSolution(t.flights()))
+		}
+	}
+}
+
+// lkTour is a tour in which consecutive cities need not have a flight
+// between them on the day, such missing flight costs penalty, so chains
+// can pass through tours that are not complete
+type lkTour struct {
+	g       Graph
+	cities  []City // by position, first and last is the source
+	pos     []int  // position of city, source is at 0
+	cost    int64  // of existing flights
+	missing int64
+	penalty int64
+}
+
+func newLkTour(g Graph, flights []Flight, penalty int64) *lkTour {
+	n := len(flights)
+	t := &lkTour{g: g, cities: make([]City, n+1), pos: make([]int, n), penalty: penalty}
+	for d, f := range flights {
+		t.cities[d] = f.From
+		t.pos[f.From] = d
+	}
+	t.cities[n] = flights[n-1].To
+	for d := 0; d < n; d++ {
+		t.add(d, 1)
+	}
+	return t
+}
+
+// value of the tour including penalty for missing flights
+func (t *lkTour) value() int64 {
+	return t.cost + t.missing*t.penalty
+}
+
+// add or remove (sign -1) flight of the day
+func (t *lkTour) add(d int, sign int64) {
+	if f := t.g.get(t.cities[d], Day(d), t.cities[d+1]); exists(f) {
+		t.cost += sign * int64(f.Cost)
+	} else {
+		t.missing += sign
+	}
+}
+
+// swap exchanges cities at positions i and j, flights of at most four days
+// change
+func (t *lkTour) swap(i, j int) {
+	if i == j {
+		return
+	}
+	i, j = order(i, j)
+	days := [4]int{i - 1, i, j - 1, j}
+	changed := days[:]
+	if j == i+1 {
+		changed = []int{i - 1, i, j}
+	}
+	for _, d := range changed {
+		t.add(d, -1)
+	}
+	t.cities[i], t.cities[j] = t.cities[j], t.cities[i]
+	t.pos[t.cities[i]] = i
+	t.pos[t.cities[j]] = j
+	for _, d := range changed {
+		t.add(d, 1)
+	}
+}
+
+// candidates tried at each level of chain, one at deeper levels
+var lkBreadth = []int{5, 3}
+
+// lkMove is exchange of city at chain position for the one at pos
+type lkMove struct {
+	pos   int
+	value int64
+}
+
+type byLkValue []lkMove
+
+func (m byLkValue) Len() int {
+	return len(m)
+}
+func (m byLkValue) Swap(i, j int) {
+	m[i], m[j] = m[j], m[i]
+}
+func (m byLkValue) Less(i, j int) bool {
+	return m[i].value < m[j].value
+}
+
+// chain exchanges city at position cur for one there is a flight to from
+// the previous city and continues from the position that city was taken
+// from, exchanges may make the tour worse on the way; the chain is accepted
+// once the tour gets better than it was at the start, that is when it has
+// fewer missing flights or the same number and lower value, otherwise the
+// tour is left unchanged; every evaluated exchange counts against limit
+func (t *lkTour) chain(comm comm, limit *limit, cur, depth int, locked []bool, missing, value int64) bool {
+	if depth == lkMaxDepth || limit.expired() {
+		return false
+	}
+	comm.yield()
+	breadth := 1
+	if depth < len(lkBreadth) {
+		breadth = lkBreadth[depth]
+	}
+	var moves []lkMove
+	for _, f := range t.g.fromDay(t.cities[cur-1], Day(cur-1)) {
+		j := t.pos[f.To]
+		if j == 0 || j == cur || locked[j] {
+			continue
+		}
+		t.swap(cur, j)
+		moves = append(moves, lkMove{j, t.value()})
+		t.swap(cur, j)
+	}
+	limit.count(len(moves))
+	sort.Sort(byLkValue(moves))
+	if len(moves) > breadth {
+		moves = moves[:breadth]
+	}
+	locked[cur] = true
+	defer func() { locked[cur] = false }()
+	for _, m := range moves {
+		t.swap(cur, m.pos)
+		if t.missing < missing || t.missing == missing && t.value() < value {
+			return true
+		}
+		if t.chain(comm, limit, m.pos, depth+1, locked, missing, value) {
+			return true
+		}
+		t.swap(cur, m.pos)
+	}
+	return false
+}
+
+// optimize runs chains from all positions until none of them helps
+func (t *lkTour) optimize(comm comm, rng *rand.Rand, limit *limit) {
+	n := len(t.cities) - 1
+	locked := make([]bool, n)
+	for improved := true; improved; {
+		improved = false
+		for _, p := range rng.Perm(n - 1) {
+			if limit.expired() {
+				return
+			}
+			if t.chain(comm, limit, p+1, 0, locked, t.missing, t.value()) {
+				improved = true
+			}
+		}
+	}
+}
+
+func (t *lkTour) flights() []Flight {
+	flights := make([]Flight, len(t.cities)-1)
+	for d := range flights {
+		flights[d] = *t.g.get(t.cities[d], Day(d), t.cities[d+1])
+	}
+	return flights
+}
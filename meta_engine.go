@@ -4,11 +4,21 @@ import (
 	//"container/heap"
 	"math"
 	"sync"
+	"sync/atomic"
 )
 
+// penalty of flights shared by MetaEngines, it is kept apart from flights
+// of the graph so that other engines can read them while it changes
 type penalty struct {
-	init Money
-	m    *sync.Mutex
+	init  Money
+	m     *sync.Mutex
+	graph Graph
+	// bits of float64 penalties by flight index, read atomically
+	values []uint64
+}
+
+func newPenalty(graph Graph) *penalty {
+	return &penalty{0, &sync.Mutex{}, graph, make([]uint64, len(graph.flights))}
 }
 
 func (p *penalty) save(s partial, q float64) {
@@ -19,11 +29,17 @@ func (p *penalty) save(s partial, q float64) {
 	normalized := float64(s.cost) / float64(p.init)
 	fraction := (normalized*q) / 5000
 	for _, f := range s.flights {
-		f.Penalty += fraction
+		if i := p.graph.index(f.From, f.Day, f.To); i >= 0 {
+			atomic.StoreUint64(&p.values[i], math.Float64bits(p.get(FlightIndex(i))+fraction))
+		}
 	}
 	p.m.Unlock()
 }
 
+func (p *penalty) get(i FlightIndex) float64 {
+	return math.Float64frombits(atomic.LoadUint64(&p.values[i]))
+}
+
 type heuristics func(*Flight) float64
 
 type MetaEngine struct {
@@ -44,7 +60,8 @@ func (m MetaEngine) Solve(comm comm, problem Problem) {
 	visited := make(map[City]bool)
 	partial := partial{visited, flights, problem.n, 0}
 	for {
-		f := nextFlight(m.graph.fromDaySortedCost[0][0], &partial, m.weight[0], m.h)
+		comm.yield()
+		f := nextFlight(m.graph.fromDay(0, 0), m.graph.fromDayIndex(0, 0), &partial, m.weight[0], m.h, m.p)
 		partial.fly(f)
 		partial.visited[0] = false
 		if ok := m.run(&partial); ok {
@@ -66,8 +83,8 @@ func (m *MetaEngine) run(partial *partial) bool {
 		}
 		lf := partial.lastFlight()
 		d := lf.Day + 1
-		dst := m.graph.fromDaySortedCost[lf.To][d]
-		nextFlight := nextFlight(dst, partial, m.weight[d], m.h)
+		dst := m.graph.fromDay(lf.To, d)
+		nextFlight := nextFlight(dst, m.graph.fromDayIndex(lf.To, d), partial, m.weight[d], m.h, m.p)
 		if nextFlight == nil {
 			return false
 		}
@@ -75,12 +92,14 @@ func (m *MetaEngine) run(partial *partial) bool {
 	}
 }
 
-func nextFlight(flights []*Flight, partial *partial, weight float64, h heuristics) *Flight {
+// nextFlight picks from flights, the first of them has index first
+func nextFlight(flights []Flight, first FlightIndex, partial *partial, weight float64, h heuristics, p *penalty) *Flight {
 	var cMax Money
 	var pMax float64
 	var hMax float64
 	valid := false
-	for _, f := range flights {
+	for i := range flights {
+		f := &flights[i]
 		if partial.hasVisited(f.To) {
 			continue
 		}
@@ -88,8 +107,8 @@ func nextFlight(flights []*Flight, partial *partial, weight float64, h heuristic
 		if cMax < f.Cost {
 			cMax = f.Cost
 		}
-		if pMax < f.Penalty {
-			pMax = f.Penalty
+		if pen := p.get(first + FlightIndex(i)); pMax < pen {
+			pMax = pen
 		}
 		hVal := h(f)
 		if hMax < hVal {
@@ -104,12 +123,13 @@ func nextFlight(flights []*Flight, partial *partial, weight float64, h heuristic
 	}
 	best := float64(math.MaxFloat32)
 	var bestFlight *Flight
-	for _, f := range flights {
+	for i := range flights {
+		f := &flights[i]
 		if partial.hasVisited(f.To) {
 			continue
 		}
 		ncost := float64(f.Cost) / float64(cMax)
-		npen := f.Penalty / pMax
+		npen := p.get(first+FlightIndex(i)) / pMax
 		nheur := h(f) / hMax
 		val := (1-weight)*ncost + weight*(npen+nheur)
 		if best > val {
@@ -6,9 +6,6 @@ import (
 	"time"
 )
 
-const MAX_CITIES int = 300
-const MAX_FLIGHTS int = 27000000
-
 var BeVerbose bool
 var StartTime time.Time
 
@@ -22,12 +19,8 @@ func Cost(flights []Flight) Money {
 
 // is solution correct? if not, why?
 func correct(p Problem, s Solution) (bool, string) {
-	var day Day
-	for _, f := range s.flights {
-		if day > f.Day {
-			return false, "timing"
-		}
-		day = f.Day + 1
+	if err := p.Validate(s); err != nil {
+		return false, err.Error()
 	}
 	return true, ""
 }
@@ -69,6 +69,7 @@ func (m Mitm) Solve(comm comm, problem Problem) {
 	var bestCost Money = Money(math.MaxInt32)
 	var solution Solution
 	for {
+		comm.yield()
 		select {
 		case hr, ok = <-left:
 			if !ok {
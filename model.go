@@ -0,0 +1,184 @@
+package fsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// FlightFeatures are names of features model scores flights by:
+// discount against average price of the same connection, rank by price
+// among flights from the city on that day, price relative to average,
+// flights from destination on the next day and their average price
+var FlightFeatures = []string{"discount", "rank", "cost", "dest_degree", "next_avg"}
+
+// Model is logistic regression giving probability that flight is part of
+// the cheapest route, it is trained by TrainModel on flights of best known
+// routes and used in place of hand-written heuristics when set in Options
+type Model struct {
+	Features []string `json:"features"`
+	// features are standardized by mean and scale before weighting
+	Mean    []float64 `json:"mean"`
+	Scale   []float64 `json:"scale"`
+	Weights []float64 `json:"weights"`
+	Bias    float64   `json:"bias"`
+}
+
+// check that model was trained on the features we compute
+func (m *Model) check() error {
+	n := len(FlightFeatures)
+	if len(m.Features) != n || len(m.Mean) != n || len(m.Scale) != n || len(m.Weights) != n {
+		return fmt.Errorf("model has %d features, expected %d", len(m.Features), n)
+	}
+	for i, f := range m.Features {
+		if f != FlightFeatures[i] {
+			return fmt.Errorf("model feature %d is %q, expected %q", i, f, FlightFeatures[i])
+		}
+		// a bad number would poison every probability
+		if !finite(m.Mean[i]) || !finite(m.Scale[i]) || !finite(m.Weights[i]) {
+			return fmt.Errorf("model feature %q has mean %v, scale %v and weight %v", f, m.Mean[i], m.Scale[i], m.Weights[i])
+		}
+		if m.Scale[i] == 0 {
+			return fmt.Errorf("model feature %q has zero scale", f)
+		}
+	}
+	if !finite(m.Bias) {
+		return fmt.Errorf("model bias is %v", m.Bias)
+	}
+	return nil
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Probability that flight with given features is part of the cheapest route
+func (m *Model) Probability(x []float64) float64 {
+	z := m.Bias
+	for i, v := range x {
+		z += m.Weights[i] * (v - m.Mean[i]) / m.Scale[i]
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// features of every flight of the graph, by flight index
+func graphFeatures(g Graph, stats FlightStatistics) [][]float64 {
+	features := make([][]float64, len(g.flights))
+	avg := float64(stats.AvgPrice)
+	if avg == 0 {
+		avg = 1
+	}
+	for city := 0; city < g.size; city++ {
+		for day := 0; day < g.size; day++ {
+			flights := g.fromDay(City(city), Day(day))
+			first := int(g.fromDayIndex(City(city), Day(day)))
+			for i, f := range flights {
+				x := make([]float64, len(FlightFeatures))
+				if s := stats.ByDest[f.From][f.To]; s.AvgPrice > 0 {
+					x[0] = float64(s.AvgPrice-float32(f.Cost)) / float64(s.AvgPrice)
+				}
+				x[1] = float64(i) / float64(len(flights))
+				x[2] = float64(f.Cost) / avg
+				if day+1 < g.size {
+					x[3] = float64(len(g.fromDay(f.To, Day(day+1)))) / float64(g.size)
+					if day+1 < len(stats.ByDay[f.To]) {
+						x[4] = float64(stats.ByDay[f.To][day+1].AvgPrice) / avg
+					}
+				}
+				features[first+i] = x
+			}
+		}
+	}
+	return features
+}
+
+// scores are probabilities of all flights of the graph, by flight index
+func (m *Model) scores(g Graph, stats FlightStatistics) []float64 {
+	features := graphFeatures(g, stats)
+	scores := make([]float64, len(features))
+	for i, x := range features {
+		scores[i] = m.Probability(x)
+	}
+	return scores
+}
+
+// TrainingExamples are features of flights of the problem that can be part
+// of a route, labeled true for flights of the given best known route
+func TrainingExamples(p Problem, best Solution) ([][]float64, []bool) {
+	g := NewGraph(p)
+	labels := make([]bool, len(g.flights))
+	for _, f := range best.flights {
+		if i := g.index(f.From, f.Day, f.To); i >= 0 {
+			labels[i] = true
+		}
+	}
+	return graphFeatures(g, p.stats), labels
+}
+
+// TrainModel fits model to examples by gradient descent, flights of best
+// routes are rare so they weigh as much as all the others together
+func TrainModel(x [][]float64, y []bool, epochs int, rate float64) *Model {
+	n := len(FlightFeatures)
+	m := &Model{
+		Features: FlightFeatures,
+		Mean:     make([]float64, n),
+		Scale:    make([]float64, n),
+		Weights:  make([]float64, n),
+	}
+	if len(x) == 0 {
+		for j := range m.Scale {
+			m.Scale[j] = 1
+		}
+		return m
+	}
+	for _, v := range x {
+		for j := range v {
+			m.Mean[j] += v[j] / float64(len(x))
+		}
+	}
+	for _, v := range x {
+		for j := range v {
+			d := v[j] - m.Mean[j]
+			m.Scale[j] += d * d / float64(len(x))
+		}
+	}
+	for j := range m.Scale {
+		m.Scale[j] = math.Sqrt(m.Scale[j])
+		if m.Scale[j] == 0 {
+			m.Scale[j] = 1
+		}
+	}
+
+	positive := 0
+	for _, label := range y {
+		if label {
+			positive++
+		}
+	}
+	weight := [2]float64{0.5 / float64(len(y)-positive), 0.5 / float64(positive)}
+	if positive == 0 || positive == len(y) {
+		weight = [2]float64{1 / float64(len(y)), 1 / float64(len(y))}
+	}
+	grad := make([]float64, n)
+	for e := 0; e < epochs; e++ {
+		for j := range grad {
+			grad[j] = 0
+		}
+		var gradBias float64
+		for i, v := range x {
+			target, w := 0.0, weight[0]
+			if y[i] {
+				target, w = 1, weight[1]
+			}
+			d := (m.Probability(v) - target) * w
+			for j := range v {
+				grad[j] += d * (v[j] - m.Mean[j]) / m.Scale[j]
+			}
+			gradBias += d
+		}
+		for j := range m.Weights {
+			m.Weights[j] -= rate * grad[j]
+		}
+		m.Bias -= rate * gradBias
+	}
+	return m
+}
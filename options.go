@@ -0,0 +1,134 @@
+package fsp
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Options tweak the way problem is solved
+type Options struct {
+	// Seed of random generators of all engines, 0 picks one based on time
+	Seed int64
+	// Deterministic runs engines one at a time in a fixed order, each for
+	// a fixed number of iterations, so the run can be replayed exactly
+	// using the same seed
+	Deterministic bool
+	// Rounds stops deterministic run after given number of round-robin
+	// rounds instead of timeout, 0 means run until timeout
+	Rounds int
+
+	// Effort budgets, solving stops when any of them or timeout runs out,
+	// 0 means no limit. Nodes are search nodes or iterations of engines,
+	// solutions are full routes engines have evaluated.
+	MaxNodes     uint64
+	MaxSolutions uint64
+	// stop when best solution has not improved for given time or nodes,
+	// deterministic run can not take wall time so it needs StallNodes
+	StallTime  time.Duration
+	StallNodes uint64
+
+	// Params override tunable parameters of engines by name, see Tunables
+	Params map[string]float64
+
+	// Model trained by TrainModel scores flights for Dcfs and one more
+	// MetaEngine instead of discount heuristics, nil keeps the heuristics
+	Model *Model
+
+	// Cancel stops solving once closed, best solution so far is returned
+	Cancel <-chan struct{}
+
+	// OnImprovement is called with new best solutions from its own
+	// goroutine, when it is slow improvements found meanwhile are skipped
+	// but the latest one, the last call is over before solving returns
+	OnImprovement func(Improvement)
+}
+
+// Improvement of best solution found while solving
+type Improvement struct {
+	Solution Solution
+	Engine   string
+	Elapsed  time.Duration
+}
+
+func (p Problem) SolveWithOptions(timeout <-chan time.Time, o Options) (Solution, Report, error) {
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	printInfo("Seed:", o.Seed)
+	for name := range o.Params {
+		if !tunable(name) {
+			return Solution{}, Report{}, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	if o.Deterministic && o.StallTime > 0 {
+		return Solution{}, Report{}, fmt.Errorf("stall time %v would make deterministic run unrepeatable, use stall nodes", o.StallTime)
+	}
+	if o.Model != nil {
+		if err := o.Model.check(); err != nil {
+			return Solution{}, Report{}, err
+		}
+	}
+	if o.Deterministic && (o.Rounds > 0 || o.MaxNodes > 0 || o.MaxSolutions > 0 || o.StallNodes > 0) {
+		// wall time would make the run unrepeatable
+		timeout = nil
+	}
+	s, r, err := kickTheEngines(p, timeout, o)
+	if err == nil && s.totalCost == math.MaxInt32 {
+		err = ErrNoSolution
+	}
+	return s, r, err
+}
+
+// seeder derives independent seeds for engines from a single one
+type seeder struct {
+	state uint64
+}
+
+func newSeeder(seed int64) *seeder {
+	return &seeder{uint64(seed)}
+}
+
+// splitmix64, consecutive outputs are good seeds for separate generators
+func (s *seeder) next() int64 {
+	s.state += 0x9E3779B97F4A7C15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return int64(z ^ (z >> 31))
+}
+
+// how many steps is one second of engine time worth when effort is counted
+const detStepsPerSecond = 1000000
+
+// limit stops search inside of an engine after given time, in deterministic
+// mode or with node budgets after an equivalent number of steps instead
+type limit struct {
+	timeout <-chan time.Time
+	steps   int
+	max     int
+}
+
+func timeLimit(d time.Duration) *limit {
+	return &limit{timeout: time.After(d)}
+}
+
+func stepLimit(d time.Duration) *limit {
+	return &limit{max: int(d.Seconds() * detStepsPerSecond)}
+}
+
+// count adds steps of work done between checks of the limit, time limit
+// does not need them
+func (l *limit) count(steps int) {
+	if l.max > 0 {
+		l.steps += steps
+	}
+}
+
+func (l *limit) expired() bool {
+	if l.max > 0 {
+		l.steps++
+		return l.steps > l.max
+	}
+	return expired(l.timeout)
+}
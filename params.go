@@ -0,0 +1,60 @@
+package fsp
+
+import (
+	"os"
+	"strconv"
+)
+
+// Param is a tunable parameter of an engine, it is set by Options.Params
+// or by environment variable of the same name
+type Param struct {
+	Name string
+	// range worth searching when tuning
+	Min     float64
+	Max     float64
+	Integer bool
+}
+
+// Tunables lists parameters of engines that can be tuned
+var Tunables = []Param{
+	{"DCFS_MAX_BRANCHES", 1, 10, true},
+	{"DCFS_DISC_W", 0, 2, false},
+	{"DCFS_NEXT_AVG_W", -1, 1, false},
+	{"DCFS_MIN_DISC", -0.5, 0.5, false},
+	{"DCFS_DISC_THRESH", 0, 1000, true},
+	{"DCFS_SPLIT_DEPTH", 1, 4, true},
+	{"SITM_MAX_BRANCHES", 1, 10, true},
+	{"SITM_DISC_W", 0, 2, false},
+	{"SITM_MIN_DISC", -0.5, 0.5, false},
+	{"SITM_DISC_THRESH", 0, 1000, true},
+	{"ANT_EVAPORATE", 0.01, 0.5, false},
+	{"ANT_FEROM_C", 0, 2, false},
+	{"ANT_PRICE_C", 0, 4, false},
+	{"ANT_POLISH", 0, 1, true},
+}
+
+func tunable(name string) bool {
+	for _, t := range Tunables {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// params of engines given in options, environment is used for those
+// missing there and default of the engine for the rest
+type params map[string]float64
+
+func (p params) get(name string, def float64) float64 {
+	if v, ok := p[name]; ok {
+		return v
+	}
+	if env := os.Getenv(name); len(env) > 0 {
+		if v, err := strconv.ParseFloat(env, 64); err == nil {
+			return v
+		}
+		printInfo("Ignoring malformed", name, env)
+	}
+	return def
+}
@@ -1,23 +1,32 @@
 package fsp
 
 import (
+	"math"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Polisher struct {
 	graph  Graph
-	update chan update
+	queue  *polishQueue
+	active *int32 // number of running polishing goroutines
+	rng    *rand.Rand
+	inline bool // polish in own goroutine one by one, deterministic mode
 }
 
 func (p Polisher) Name() string {
 	return "Polisher"
 }
 
-func NewPolisher(graph Graph) Polisher {
+func NewPolisher(graph Graph, seed int64, inline bool) Polisher {
 	return Polisher{
 		graph,
-		make(chan update, 100),
+		newPolishQueue(polishQueueSize),
+		new(int32),
+		rand.New(rand.NewSource(seed)),
+		inline,
 	}
 }
 
@@ -25,14 +34,43 @@ func (p Polisher) try(u update) {
 	if len(u.solution.flights) < 5 {
 		return
 	}
-	p.update <- u
+	p.queue.push(u)
 }
 
 func (p Polisher) Solve(comm comm, problem Problem) {
-	for u := range p.update {
-		go p.run2(comm, u)
-		go p.run3(comm, u)
+	if p.inline {
+		// nobody polishes until it's our turn, one by one
+		for !p.queue.isClosed() {
+			if u, ok := p.queue.pop(false); ok {
+				p.ils(comm, u, p.rng.Int63())
+			} else {
+				comm.idle()
+			}
+		}
+		return
 	}
+	var wg sync.WaitGroup
+	for w := 0; w < maxPolishers; w++ {
+		wg.Add(1)
+		rng := rand.New(rand.NewSource(p.rng.Int63()))
+		go func() {
+			defer wg.Done()
+			for {
+				u, ok := p.queue.pop(true)
+				if !ok {
+					return
+				}
+				p.polish(func() { p.ils(comm, u, rng.Int63()) })
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p Polisher) polish(run func()) {
+	atomic.AddInt32(p.active, 1)
+	defer atomic.AddInt32(p.active, -1)
+	run()
 }
 
 func exists(f *Flight) bool {
@@ -48,8 +86,7 @@ fiPrev fi     fjPrev fj
 a->d   d->c   c->b   b->a
 giPrev gi     gjPrev gj
 */
-func swap(comm comm, g Graph, u update, i, j int) {
-	flights := u.solution.flights
+func swap(g Graph, flights []Flight, i, j int) []Flight {
 	prevI := i - 1
 	prevJ := j - 1
 	fiPrev := flights[prevI]
@@ -78,9 +115,10 @@ func swap(comm comm, g Graph, u update, i, j int) {
 			for x := j + 1; x < len(flights); x++ {
 				swapped[x] = flights[x]
 			}
-			comm.send(NewSolution(swapped), u.originalEngine)
+			return swapped
 		}
 	}
+	return nil
 }
 
 /*
@@ -92,8 +130,7 @@ fiPrev fi     fjPrev fj     fkPrev fk
 a->d   d->c   c->f   f->e   e->b   b->g   g->a
 giPrev gi     gjPrev gj     gkPrev gk
 */
-func swap3a(comm comm, g Graph, u update, i, j, k int) {
-	flights := u.solution.flights
+func swap3a(g Graph, flights []Flight, i, j, k int) []Flight {
 	prevI := i - 1
 	prevJ := j - 1
 	prevK := k - 1
@@ -132,12 +169,12 @@ func swap3a(comm comm, g Graph, u update, i, j, k int) {
 			for x := k + 1; x < len(flights); x++ {
 				swapped[x] = flights[x]
 			}
-			comm.send(NewSolution(swapped), u.originalEngine)
+			return swapped
 		}
 	}
+	return nil
 }
-func swap3b(comm comm, g Graph, u update, i, j, k int) {
-	flights := u.solution.flights
+func swap3b(g Graph, flights []Flight, i, j, k int) []Flight {
 	prevI := i - 1
 	prevJ := j - 1
 	prevK := k - 1
@@ -176,21 +213,10 @@ func swap3b(comm comm, g Graph, u update, i, j, k int) {
 			for x := k + 1; x < len(flights); x++ {
 				swapped[x] = flights[x]
 			}
-			comm.send(NewSolution(swapped), u.originalEngine)
+			return swapped
 		}
 	}
-}
-
-func (p Polisher) run2(comm comm, u update) {
-	//start := time.Now()
-	n := len(u.solution.flights)
-	max := n - 1
-	for i := 1; i < max; i++ {
-		for j := i + 1; j <= max; j++ {
-			swap(comm, graph, u, i, j)
-		}
-	}
-	//printInfo("polisher2 done in", time.Since(start))
+	return nil
 }
 
 func order(i, j int) (int, int) {
@@ -200,48 +226,236 @@ func order(i, j int) (int, int) {
 	return j, i
 }
 
-func order3(i, j, k int) (int, int, int) {
-	i, j = order(i, j)
-	i, k = order(i, k)
-	j, k = order(j, k)
-	return i, j, k
-}
-
-func (p Polisher) run3(comm comm, u update) {
-	//start := time.Now()
-	n := len(u.solution.flights)
-	if n < 130 {
-		maxi := n - 2
-		maxj := n - 1
-		for i := 1; i < maxi; i++ {
-			for j := i + 1; j < maxj; j++ {
-				for k := j + 1; k <= maxj; k++ {
-					swap3a(comm, graph, u, i, j, k)
-					swap3b(comm, graph, u, i, j, k)
-				}
+// cities of the route by position and cost of its first d flights, moves
+// below change every flight between two positions as days of cities in
+// between shift
+type route struct {
+	cities []City
+	cost   []Money
+}
+
+func newRoute(flights []Flight) route {
+	r := route{make([]City, len(flights)+1), make([]Money, len(flights)+1)}
+	for d, f := range flights {
+		r.cities[d] = f.From
+		r.cost[d+1] = r.cost[d] + f.Cost
+	}
+	r.cities[len(flights)] = flights[len(flights)-1].To
+	return r
+}
+
+// tryMove gives the route with flights of days from to to replaced when it
+// is cheaper, city gives cities of the new route by position
+func tryMove(g Graph, flights []Flight, r route, from, to int, city func(int) City) []Flight {
+	return move(g, flights, r.cost[to+1]-r.cost[from], from, to, city)
+}
+
+// move replaces flights of days from to to when new ones cost less than
+// limit, nil when they don't or some of them don't exist
+func move(g Graph, flights []Flight, limit Money, from, to int, city func(int) City) []Flight {
+	var cost Money
+	for d := from; d <= to; d++ {
+		f := g.get(city(d), Day(d), city(d+1))
+		if !exists(f) {
+			return nil
+		}
+		cost += f.Cost
+		if cost >= limit {
+			return nil
+		}
+	}
+	moved := make([]Flight, len(flights))
+	copy(moved, flights)
+	for d := from; d <= to; d++ {
+		moved[d] = *g.get(city(d), Day(d), city(d+1))
+	}
+	return moved
+}
+
+/*
+2-opt, cities i..j are visited in reverse order, i = 1 and j = 3 below
+0 ---- 1 ---- 2 ---- 3 ---- 4 ---- 5
+A      B      C      D      E      A
+A      D      C      B      E      A
+*/
+func twoOpt(g Graph, flights []Flight, r route, i, j int) []Flight {
+	return tryMove(g, flights, r, i-1, j, func(x int) City {
+		if x >= i && x <= j {
+			return r.cities[i+j-x]
+		}
+		return r.cities[x]
+	})
+}
+
+/*
+Or-opt, l cities starting at i move right after city k, i = 1, l = 1 and
+k = 3 below
+0 ---- 1 ---- 2 ---- 3 ---- 4 ---- 5
+A      B      C      D      E      A
+A      C      D      B      E      A
+*/
+func orOpt(g Graph, flights []Flight, r route, i, l, k int) []Flight {
+	if k >= i+l {
+		return tryMove(g, flights, r, i-1, k, func(x int) City {
+			switch {
+			case x < i || x > k:
+				return r.cities[x]
+			case x <= k-l:
+				return r.cities[x+l]
+			}
+			return r.cities[i+x-(k-l+1)]
+		})
+	} else if k < i-1 {
+		return tryMove(g, flights, r, k, i+l-1, func(x int) City {
+			switch {
+			case x <= k || x >= i+l:
+				return r.cities[x]
+			case x <= k+l:
+				return r.cities[i+x-(k+1)]
 			}
+			return r.cities[x-l]
+		})
+	}
+	return nil
+}
+
+// segments of up to this many cities are moved by Or-opt
+const orOptMaxLen = 3
+
+// polishing workers running at the same time
+const maxPolishers = 2
+
+// local search of one solution is given this long
+const polishTime = 3 * time.Second
+
+// iterated local search gives up after this many kicks in a row that did
+// not lead to a better local optimum
+const ilsKicks = 20
+
+// full neighbourhood of 3-city rotations is searched only for smaller
+// problems, it grows with the cube of cities
+const swap3MaxCities = 130
+
+// ils polishes solution to local optimum taking the best improving move
+// until there is none, then kicks the best local optimum by double bridge
+// and polishes again until kicks stop helping
+func (p Polisher) ils(comm comm, u update, rndSeed int64) {
+	if len(u.solution.flights) < 5 {
+		return
+	}
+	rng := rand.New(rand.NewSource(rndSeed))
+	limit := comm.newLimit(polishTime)
+	best := u.solution
+	current := u.solution.flights
+	for fails := 0; fails < ilsKicks; {
+		optimum := NewSolution(p.descend(comm, current, limit))
+		if optimum.totalCost < best.totalCost {
+			best = optimum
+			comm.send(best, u.originalEngine)
+			fails = 0
+		} else {
+			fails++
+		}
+		if limit.expired() {
+			return
+		}
+		current = p.kick(best.flights, rng)
+		if current == nil {
+			return
+		}
+	}
+}
+
+// descend applies the best improving move as long as there is one
+func (p Polisher) descend(comm comm, flights []Flight, limit *limit) []Flight {
+	for !limit.expired() {
+		next := p.bestMove(comm, flights, limit)
+		if next == nil {
+			break
 		}
-	} else {
-		timeout := time.After(3 * time.Second)
-		seed := rand.New(rand.NewSource(time.Now().UnixNano()))
+		flights = next
+	}
+	return flights
+}
 
-		for !expired(timeout) {
-			i := seed.Intn(n-1) + 1
-			j := seed.Intn(n-1) + 1
-			k := seed.Intn(n-1) + 1
-			i, j, k = order3(i, j, k)
-			if i > j || j > k || i > k {
-				//should not happen but in case there is
-				//bug again in order3, this prevents bullshit
-				continue
+// bestMove finds the cheapest route one move away, nil if none is cheaper,
+// every move evaluated counts as a step of the limit and search ends early
+// when it expires
+func (p Polisher) bestMove(comm comm, flights []Flight, limit *limit) []Flight {
+	g := p.graph
+	n := len(flights)
+	r := newRoute(flights)
+	var best []Flight
+	bestCost := r.cost[n]
+	evaluated := 0
+	consider := func(moved []Flight) {
+		evaluated++
+		if moved == nil {
+			return
+		}
+		if cost := Cost(moved); cost < bestCost {
+			best, bestCost = moved, cost
+		}
+	}
+	for i := 1; i < n; i++ {
+		comm.yield()
+		for j := i + 1; j < n; j++ {
+			consider(swap(g, flights, i, j))
+			consider(twoOpt(g, flights, r, i, j))
+			if n < swap3MaxCities {
+				for k := j + 1; k < n; k++ {
+					consider(swap3a(g, flights, i, j, k))
+					consider(swap3b(g, flights, i, j, k))
+				}
 			}
-			if i == j || j == k {
-				continue
+		}
+		for l := 1; l <= orOptMaxLen && i+l <= n; l++ {
+			for k := 0; k < n; k++ {
+				consider(orOpt(g, flights, r, i, l, k))
 			}
-			swap3a(comm, graph, u, i, j, k)
-			swap3b(comm, graph, u, i, j, k)
+		}
+		limit.count(evaluated)
+		evaluated = 0
+		if limit.expired() {
+			break
 		}
 	}
+	return best
+}
 
-	//printInfo("polisher3 done in", time.Since(start))
+// attempts to find double bridge kick with all flights existing
+const kickTries = 100
+
+/*
+double bridge, segments B and C swap places, the route usually gets worse
+but leaves the neighbourhood of local optimum descend can't escape
+a = 1, b = 3 and c = 5 below
+0 ---- 1 ---- 2 ---- 3 ---- 4 ---- 5 ---- 6
+A      B      C      D      E      F      A
+A      D      E      B      C      F      A
+*/
+func (p Polisher) kick(flights []Flight, rng *rand.Rand) []Flight {
+	n := len(flights)
+	r := newRoute(flights)
+	for try := 0; try < kickTries; try++ {
+		a, b := order(rng.Intn(n-1)+1, rng.Intn(n-1)+1)
+		b, c := order(b, rng.Intn(n-1)+1)
+		a, b = order(a, b)
+		if a == b || b == c {
+			continue
+		}
+		kicked := move(p.graph, flights, math.MaxInt32, a-1, c-1, func(x int) City {
+			switch {
+			case x < a || x >= c:
+				return r.cities[x]
+			case x < a+c-b:
+				return r.cities[b+x-a]
+			}
+			return r.cities[x+b-c]
+		})
+		if kicked != nil {
+			return kicked
+		}
+	}
+	return nil
 }
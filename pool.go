@@ -0,0 +1,115 @@
+package fsp
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// number of solutions kept in elite pool
+const poolSize = 16
+
+// elitePool is a bounded set of good solutions shared by all engines,
+// solutions too similar to a better one already in pool are not kept
+// so that engines seeding from it do not all end up in the same place
+type elitePool struct {
+	m       sync.RWMutex
+	size    int
+	minDist int
+	elite   []Solution // sorted by cost, best first
+}
+
+func newElitePool(n int) *elitePool {
+	return &elitePool{
+		size:    poolSize,
+		minDist: n/10 + 1,
+		elite:   make([]Solution, 0, poolSize),
+	}
+}
+
+// number of days on which the two solutions visit different city
+func distance(a, b Solution) int {
+	d := 0
+	for i := range a.flights {
+		if i >= len(b.flights) || a.flights[i].To != b.flights[i].To {
+			d++
+		}
+	}
+	return d
+}
+
+func (p *elitePool) worst() Money {
+	if len(p.elite) < p.size {
+		return Money(math.MaxInt32)
+	}
+	return p.elite[len(p.elite)-1].totalCost
+}
+
+// add offers solution to pool, returns true if it was accepted
+func (p *elitePool) add(s Solution) bool {
+	if p == nil || len(s.flights) == 0 {
+		return false
+	}
+	p.m.RLock()
+	worse := s.totalCost >= p.worst()
+	p.m.RUnlock()
+	if worse {
+		return false
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if s.totalCost >= p.worst() {
+		return false
+	}
+	// routes of engines need not be in day order, distance compares days
+	flights := make([]Flight, len(s.flights))
+	copy(flights, s.flights)
+	sort.Sort(ByDay(flights))
+	s = Solution{flights, s.totalCost}
+	for _, e := range p.elite {
+		if distance(e, s) < p.minDist && e.totalCost <= s.totalCost {
+			// we already have something similar and better
+			return false
+		}
+	}
+	// all similar solutions are worse, s takes their place
+	kept := p.elite[:0]
+	for _, e := range p.elite {
+		if distance(e, s) >= p.minDist {
+			kept = append(kept, e)
+		}
+	}
+	p.elite = kept
+	if len(p.elite) < p.size {
+		p.elite = append(p.elite, s)
+	} else {
+		p.elite[len(p.elite)-1] = s
+	}
+	sort.Sort(byTotalCost(p.elite))
+	return true
+}
+
+// snapshot of current pool, best first; solutions must not be modified
+func (p *elitePool) get() []Solution {
+	if p == nil {
+		return nil
+	}
+	p.m.RLock()
+	defer p.m.RUnlock()
+	elite := make([]Solution, len(p.elite))
+	copy(elite, p.elite)
+	return elite
+}
+
+type byTotalCost []Solution
+
+func (s byTotalCost) Len() int {
+	return len(s)
+}
+func (s byTotalCost) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s byTotalCost) Less(i, j int) bool {
+	return s[i].totalCost < s[j].totalCost
+}
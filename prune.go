@@ -0,0 +1,236 @@
+package fsp
+
+// prune tells which flights of the problem can be part of a valid route,
+// the rest is left out of the graph so that no engine wastes time on them
+//
+// flights are grouped by day and departure city, then we repeat until
+// nothing changes:
+//   - flight is kept only if we can be in its departure city on its day
+//     and can get back home from its destination in time
+//   - city all kept flights arrive to on the same day has to be visited that
+//     day, so flights to other cities that day are dropped
+//
+// of more flights between two cities on the same day only the cheapest one
+// is kept; when two cities have to be visited on the same day there is no
+// route, the conflict is returned and only the cheapest flights are kept
+func prune(p Problem, n int, source City) ([]bool, *Infeasibility) {
+	fb := newFlightBuckets(p, n, source)
+	if n == 0 {
+		return fb.keep(), nil
+	}
+	order, start := fb.order, fb.start
+	removed := make([]bool, len(fb.removed))
+	copy(removed, fb.removed)
+
+	at := make([][]bool, n+1) // can be in city at the start of day
+	home := make([][]bool, n) // can get home when in city at the start of day
+	for d := range at {
+		at[d] = make([]bool, n)
+	}
+	for d := range home {
+		home[d] = make([]bool, n)
+	}
+	arrival := make([]int, n)
+	var conflict *Infeasibility
+	for changed := true; changed; {
+		changed = false
+		for d := range at {
+			for c := range at[d] {
+				at[d][c] = false
+			}
+		}
+		at[0][source] = true
+		for d := 0; d < n; d++ {
+			for from := 0; from < n; from++ {
+				if !at[d][from] {
+					continue
+				}
+				b := d*n + from
+				for o := start[b]; o < start[b+1]; o++ {
+					if !removed[o] {
+						at[d+1][p.flights[order[o]].To] = true
+					}
+				}
+			}
+		}
+		for d := n - 1; d >= 0; d-- {
+			for from := 0; from < n; from++ {
+				home[d][from] = false
+				b := d*n + from
+				for o := start[b]; o < start[b+1]; o++ {
+					to := p.flights[order[o]].To
+					if !removed[o] && (d == n-1 || home[d+1][to]) {
+						home[d][from] = true
+						break
+					}
+				}
+			}
+		}
+		for i := range arrival {
+			arrival[i] = -1
+		}
+		for d := 0; d < n; d++ {
+			for from := 0; from < n; from++ {
+				b := d*n + from
+				for o := start[b]; o < start[b+1]; o++ {
+					if removed[o] {
+						continue
+					}
+					to := p.flights[order[o]].To
+					if !at[d][from] || d < n-1 && !home[d+1][to] {
+						removed[o] = true
+						changed = true
+						continue
+					}
+					switch arrival[to] {
+					case -1:
+						arrival[to] = d
+					case d:
+					default:
+						arrival[to] = n // more days
+					}
+				}
+			}
+		}
+		if changed {
+			continue
+		}
+		// cities which have to be visited on a particular day
+		fixed := make([]int, n)
+		for i := range fixed {
+			fixed[i] = -1
+		}
+		for c, d := range arrival {
+			if City(c) == source || d < 0 || d == n {
+				continue
+			}
+			if fixed[d] >= 0 && conflict == nil {
+				conflict = &Infeasibility{Reason: sameDay, Days: []Day{Day(d)}}
+				for other, od := range arrival {
+					if od == d && City(other) != source {
+						conflict.Cities = append(conflict.Cities, City(other))
+					}
+				}
+			}
+			fixed[d] = c
+		}
+		if conflict != nil {
+			// two cities on the same day, there is no route at all
+			break
+		}
+		for d, c := range fixed {
+			if c < 0 {
+				continue
+			}
+			for from := 0; from < n; from++ {
+				b := d*n + from
+				for o := start[b]; o < start[b+1]; o++ {
+					if !removed[o] && p.flights[order[o]].To != City(c) {
+						removed[o] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	infeasible := conflict != nil
+	for c, d := range arrival {
+		infeasible = infeasible || City(c) != source && d < 0
+	}
+	if !infeasible {
+		// otherwise leave it to feasibility check to explain why there is
+		// no route
+		fb.removed = removed
+	}
+	return fb.keep(), conflict
+}
+
+// flightBuckets are flights that can be part of some route judging by
+// their own day, departure and destination only, grouped by day and
+// departure city, flights of bucket day*n+from are
+// order[start[b]:start[b+1]], removed are those with a cheaper flight to
+// the same destination
+type flightBuckets struct {
+	flights int
+	order   []int32
+	start   []int
+	removed []bool
+}
+
+func newFlightBuckets(p Problem, n int, source City) flightBuckets {
+	fb := flightBuckets{flights: len(p.flights)}
+	if n == 0 {
+		return fb
+	}
+	lastDay := Day(n - 1)
+	// group flights by day and departure city, cheapest to each destination
+	// only
+	buckets := n * n
+	start := make([]int, buckets+1)
+	cheapest := make([]int, n)
+	candidate := func(f *Flight) bool {
+		switch {
+		case int(f.From) >= n || int(f.To) >= n || f.Day > lastDay:
+			return false
+		case f.To == source && f.Day != lastDay:
+			// no need to append paths to home city before last day
+			return false
+		case f.To != source && f.Day == lastDay:
+			// no need to append paths to another city on last day
+			return false
+		case f.From == source && f.Day != 0:
+			return false
+		case f.From == f.To && n > 1:
+			return false
+		}
+		return true
+	}
+	for i := range p.flights {
+		if candidate(&p.flights[i]) {
+			start[int(p.flights[i].Day)*n+int(p.flights[i].From)+1]++
+		}
+	}
+	for b := 0; b < buckets; b++ {
+		start[b+1] += start[b]
+	}
+	order := make([]int32, start[buckets])
+	next := make([]int, buckets)
+	copy(next, start)
+	for i := range p.flights {
+		if candidate(&p.flights[i]) {
+			b := int(p.flights[i].Day)*n + int(p.flights[i].From)
+			order[next[b]] = int32(i)
+			next[b]++
+		}
+	}
+	removed := make([]bool, len(order))
+	for b := 0; b < buckets; b++ {
+		for i := range cheapest {
+			cheapest[i] = -1
+		}
+		for o := start[b]; o < start[b+1]; o++ {
+			f := &p.flights[order[o]]
+			c := cheapest[f.To]
+			if c < 0 {
+				cheapest[f.To] = o
+			} else if f.Cost < p.flights[order[c]].Cost {
+				removed[c] = true
+				cheapest[f.To] = o
+			} else {
+				removed[o] = true
+			}
+		}
+	}
+	fb.order, fb.start, fb.removed = order, start, removed
+	return fb
+}
+
+// keep tells which flights of the problem are in buckets and not removed
+func (b flightBuckets) keep() []bool {
+	keep := make([]bool, b.flights)
+	for o, i := range b.order {
+		keep[i] = !b.removed[o]
+	}
+	return keep
+}
@@ -0,0 +1,105 @@
+package fsp
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// number of solutions waiting for polisher
+const polishQueueSize = 16
+
+// polishQueue keeps the cheapest solutions waiting to be polished, pushing
+// never blocks so the coordinator does not stall when polisher is busy
+type polishQueue struct {
+	m      sync.Mutex
+	ready  *sync.Cond
+	size   int
+	items  []update // sorted by cost, cheapest first
+	seen   map[uint64]bool
+	closed bool
+}
+
+func newPolishQueue(size int) *polishQueue {
+	q := &polishQueue{
+		size:  size,
+		items: make([]update, 0, size),
+		seen:  make(map[uint64]bool),
+	}
+	q.ready = sync.NewCond(&q.m)
+	return q
+}
+
+// tourHash identifies route by order of cities, graph keeps single flight
+// between two cities on a day so it identifies the flights as well
+func tourHash(flights []Flight) uint64 {
+	h := fnv.New64a()
+	b := make([]byte, 4*len(flights))
+	for i, f := range flights {
+		b[4*i] = byte(f.To)
+		b[4*i+1] = byte(f.To >> 8)
+		b[4*i+2] = byte(f.To >> 16)
+		b[4*i+3] = byte(f.To >> 24)
+	}
+	h.Write(b)
+	return h.Sum64()
+}
+
+// push offers solution for polishing, returns false when it was dropped as
+// it was queued or polished before or there are enough cheaper ones
+func (q *polishQueue) push(u update) bool {
+	hash := tourHash(u.solution.flights)
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed || q.seen[hash] {
+		return false
+	}
+	if len(q.items) == q.size {
+		if q.items[q.size-1].solution.totalCost <= u.solution.totalCost {
+			return false
+		}
+		// evicted one may come again later
+		delete(q.seen, tourHash(q.items[q.size-1].solution.flights))
+		q.items = q.items[:q.size-1]
+	}
+	q.seen[hash] = true
+	i := sort.Search(len(q.items), func(i int) bool {
+		return q.items[i].solution.totalCost > u.solution.totalCost
+	})
+	q.items = append(q.items, update{})
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = u
+	q.ready.Signal()
+	return true
+}
+
+// pop takes the cheapest solution, when wait is set it waits for one until
+// queue is closed, false when there is none
+func (q *polishQueue) pop(wait bool) (update, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for wait && len(q.items) == 0 && !q.closed {
+		q.ready.Wait()
+	}
+	if len(q.items) == 0 || q.closed {
+		return update{}, false
+	}
+	u := q.items[0]
+	q.items = q.items[:copy(q.items, q.items[1:])]
+	return u, true
+}
+
+func (q *polishQueue) isClosed() bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.closed
+}
+
+// close drops waiting solutions and wakes up everybody waiting for them
+func (q *polishQueue) close() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.closed = true
+	q.items = nil
+	q.ready.Broadcast()
+}
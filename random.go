@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
-	"time"
 	//"os"
 	//"sort"
 	//"github.com/pkg/profile"
@@ -13,42 +12,41 @@ import (
 // Freaky engine finding pseudo-random paths
 type RandomEngine struct {
 	graph Graph
-	seed  int
+	seed  int64
 }
 
-var RandomEngineResultsCounter uint32
-var randomCurrentBest = Money(math.MaxInt32)
-
 func (e RandomEngine) Name() string {
 	return fmt.Sprintf("%s(%d)", "RndEngine", e.seed)
 }
 
 func (e RandomEngine) Solve(comm comm, p Problem) {
 	//defer profile.Start(/*profile.MemProfile*/).Stop()
-	rand.Seed(int64(e.seed) + time.Now().UTC().UnixNano())
-	randomSolver(e.graph, comm, p.stats)
+	rng := rand.New(rand.NewSource(e.seed))
+	randomSolver(e.graph, comm, p.stats, rng)
 	//comm.done()
 }
 
-func randomSolver(graph Graph, comm comm, stats FlightStatistics) {
+func randomSolver(graph Graph, comm comm, stats FlightStatistics, rng *rand.Rand) {
 	solution := make([]Flight, 0, graph.size)
+	best := Money(math.MaxInt32)
 	var price Money
 	var city City
 	var toGo Day
 	for {
+		comm.yield()
 		solution = solution[:0]
-		visited := make([]City, 0, MAX_CITIES)
+		visited := make([]City, 0, graph.size)
 		city = City(0)
 		price = Money(0)
 		toGo = Day(graph.size)
 		for d := 0; d < graph.size; d++ {
 			//solution, city, price = randomFly(graph, solution, visited, d, city, price)
-			flight, r := randomFlight(graph, visited, Day(d), toGo, city, stats)
+			flight, r := randomFlight(graph, visited, Day(d), toGo, city, stats, rng)
 			if !r {
 				break
 			}
 			price += flight.Cost
-			if price >= randomCurrentBest {
+			if price >= best {
 				break
 			}
 			city = flight.To
@@ -56,11 +54,10 @@ func randomSolver(graph Graph, comm comm, stats FlightStatistics) {
 			solution = append(solution, flight)
 			toGo--
 		}
-		if len(solution) == graph.size /*&& price < randomCurrentBest*/ {
-			randomCurrentBest = price
+		if len(solution) == graph.size /*&& price < best*/ {
+			best = price
 			comm.sendSolution(NewSolution(solution))
 		}
-		RandomEngineResultsCounter++
 	}
 }
 
@@ -73,10 +70,10 @@ func randomFly(graph Graph, solution []Flight, visited []City, day Day, city Cit
 	flight := graph.data[city][day][rand.Intn(flightCnt)]
 	return append(solution, flight), flight.To, price + flight.Cost
 }*/
-func randomFlight(graph Graph, visited []City, day, toGo Day, city City, stats FlightStatistics) (Flight, bool) {
-	possible_flights := make([]Flight, 0, MAX_CITIES)
+func randomFlight(graph Graph, visited []City, day, toGo Day, city City, stats FlightStatistics, rng *rand.Rand) (Flight, bool) {
+	possible_flights := make([]Flight, 0, graph.size)
 	//progress := 1.0 - (float32(toGo)/float32(graph.size))
-	for _, f := range graph.data[city][day] {
+	for _, f := range graph.fromDay(city, day) {
 		if contains(visited, f.To) {
 			continue
 		}
@@ -88,13 +85,13 @@ func randomFlight(graph Graph, visited []City, day, toGo Day, city City, stats F
 			// no discount, no deal, bro
 			continue
 		}
-		possible_flights = append(possible_flights, *f)
+		possible_flights = append(possible_flights, f)
 	}
 	flightCnt := len(possible_flights)
 
 	if flightCnt == 0 {
 		return Flight{0, 0, 0, 0, 0, 0.0}, false
 	}
-	flight := possible_flights[rand.Intn(flightCnt)]
+	flight := possible_flights[rng.Intn(flightCnt)]
 	return flight, true
 }
@@ -0,0 +1,263 @@
+package fsp
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// nodes of the shortest run, schedules give runs multiples of it
+const restartUnit = 10000
+
+// geometric schedule gives every run this many times more nodes
+const restartGrowth = 1.5
+
+// candidates priced within this share of the cheapest one of their group
+// are near-equal, later runs try them in random order
+const restartTie = 0.05
+
+// nogoods kept at most, no more are recorded once there are this many
+const maxNogoods = 1 << 20
+
+// restartSchedule gives number of nodes run can explore before it is cut
+// and the search starts over
+type restartSchedule func(run int) int
+
+// lubySchedule gives run i unit times i-th element of Luby sequence
+// 1 1 2 1 1 2 4 1 1 2 ..., short runs keep coming between long ones
+func lubySchedule(unit int) restartSchedule {
+	return func(run int) int {
+		return unit * luby(run+1)
+	}
+}
+
+func luby(i int) int {
+	for k := uint(1); ; k++ {
+		if i == 1<<k-1 {
+			return 1 << (k - 1)
+		}
+		if i < 1<<k-1 {
+			return luby(i - 1<<(k-1) + 1)
+		}
+	}
+}
+
+// geometricSchedule gives every run growth times more nodes than the
+// previous one
+func geometricSchedule(unit int, growth float64) restartSchedule {
+	return func(run int) int {
+		return int(math.Min(float64(unit)*math.Pow(growth, float64(run)), math.MaxInt32))
+	}
+}
+
+// restarts drive DFS engine through runs cut after number of nodes given
+// by schedule; first run follows the order of the engine, later ones try
+// near-equal candidates in random order so that they dive into different
+// subtrees, subtrees searched to the end are remembered as nogoods and
+// skipped; engines opt in by counting their nodes by step, nil restarts
+// never cut the search
+type restarts struct {
+	schedule restartSchedule
+	rng      *rand.Rand
+	nogoods  *nogoods
+	run      int
+	nodes    int
+	budget   int
+}
+
+func newRestarts(schedule restartSchedule, seed int64, ng *nogoods) *restarts {
+	r := &restarts{schedule: schedule, rng: rand.New(rand.NewSource(seed)), nogoods: ng}
+	r.begin(0)
+	return r
+}
+
+// begin starts given run with budget of the schedule
+func (r *restarts) begin(run int) {
+	r.run = run
+	r.nodes = 0
+	r.budget = r.schedule(run)
+}
+
+func (r *restarts) next() {
+	r.begin(r.run + 1)
+}
+
+// step counts a node, true means the run is over and the search should
+// return
+func (r *restarts) step() bool {
+	if r == nil {
+		return false
+	}
+	r.nodes++
+	return r.nodes > r.budget
+}
+
+// order of candidates sorted by value, candidates within tie plus share of
+// the value of the first one of their group are shuffled in runs after the
+// first one
+func (r *restarts) order(values []float64, tie, share float64) []int {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	if r == nil || r.run == 0 {
+		return order
+	}
+	for start := 0; start < len(values); {
+		end := start + 1
+		for end < len(values) && values[end]-values[start] <= tie+share*values[start] {
+			end++
+		}
+		group := order[start:end]
+		for i := len(group) - 1; i > 0; i-- {
+			j := r.rng.Intn(i + 1)
+			group[i], group[j] = group[j], group[i]
+		}
+		start = end
+	}
+	return order
+}
+
+// flightOrder orders flights sorted by cost, flights costing up to
+// restartTie more than the cheapest one of their group are near-equal
+func (r *restarts) flightOrder(flights []Flight) []int {
+	values := make([]float64, len(flights))
+	for i, f := range flights {
+		values[i] = float64(f.Cost)
+	}
+	return r.order(values, 0, restartTie)
+}
+
+// key of route started in home, see nogoods
+func (r *restarts) start(home City) nogoodKey {
+	if r == nil {
+		return nogoodKey{}
+	}
+	return r.nogoods.start(home)
+}
+
+func (r *restarts) visit(key nogoodKey, c City) nogoodKey {
+	if r == nil {
+		return nogoodKey{}
+	}
+	return r.nogoods.visit(key, c)
+}
+
+func (r *restarts) state(key nogoodKey, c City, day Day) nogoodState {
+	if r == nil {
+		return nogoodState{}
+	}
+	return r.nogoods.state(key, c, day)
+}
+
+// failed tells state was searched to the end at lower or equal price
+func (r *restarts) failed(state nogoodState, price Money) bool {
+	return r != nil && r.nogoods.failed(state, price)
+}
+
+// fail records state searched to the end at price
+func (r *restarts) fail(state nogoodState, price Money) {
+	if r != nil {
+		r.nogoods.fail(state, price)
+	}
+}
+
+// nogoods are states of search, that is the city we are in on a day
+// having visited some cities, whose subtree was searched to the end; any
+// route through the state costing at least as much as it did then can't
+// beat the best one, it is safe to use from more goroutines
+type nogoods struct {
+	m sync.Mutex
+	// random keys of visited cities, of the current city, of the day and
+	// of the city the route started in
+	keys   []uint64
+	at     []uint64
+	days   []uint64
+	home   []uint64
+	states map[uint64]nogood
+}
+
+// nogoodKey of visited cities, hash is xor of their random keys, bits
+// flipped the same way tell keys with the same hash apart: bit c for city
+// c visited, bit n+c for route started in c
+type nogoodKey struct {
+	hash uint64
+	bits []byte
+}
+
+// nogoodState is key of visited cities in city on day
+type nogoodState struct {
+	nogoodKey
+	city City
+	day  Day
+}
+
+// nogood is recorded state found by hash of its key
+type nogood struct {
+	bits  string
+	city  City
+	day   Day
+	price Money
+}
+
+func (e nogood) is(s nogoodState) bool {
+	return e.city == s.city && e.day == s.day && e.bits == string(s.bits)
+}
+
+func newNogoods(n int, seed int64) *nogoods {
+	g := &nogoods{states: make(map[uint64]nogood)}
+	rng := rand.New(rand.NewSource(seed))
+	random := func() []uint64 {
+		keys := make([]uint64, n)
+		for i := range keys {
+			keys[i] = uint64(rng.Int63())<<1 ^ uint64(rng.Int63())
+		}
+		return keys
+	}
+	g.keys, g.at, g.days, g.home = random(), random(), random(), random()
+	return g
+}
+
+// flip copies bits with bit i flipped, keys share nothing
+func (g *nogoods) flip(bits []byte, i int) []byte {
+	flipped := make([]byte, (2*len(g.keys)+7)/8)
+	copy(flipped, bits)
+	flipped[i/8] ^= 1 << uint(i%8)
+	return flipped
+}
+
+// start is key of route starting in city which counts as visited
+func (g *nogoods) start(home City) nogoodKey {
+	bits := g.flip(nil, len(g.keys)+int(home))
+	bits[int(home)/8] ^= 1 << uint(int(home)%8)
+	return nogoodKey{g.home[home] ^ g.keys[home], bits}
+}
+
+// visit adds city to key of visited cities
+func (g *nogoods) visit(key nogoodKey, c City) nogoodKey {
+	return nogoodKey{key.hash ^ g.keys[c], g.flip(key.bits, int(c))}
+}
+
+// state of route with key in city on day
+func (g *nogoods) state(key nogoodKey, c City, day Day) nogoodState {
+	key.hash ^= g.at[c] ^ g.days[int(day)%len(g.days)]
+	return nogoodState{key, c, day}
+}
+
+func (g *nogoods) failed(state nogoodState, price Money) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+	e, ok := g.states[state.hash]
+	return ok && e.is(state) && e.price <= price
+}
+
+// fail records state, another one with the same hash is replaced
+func (g *nogoods) fail(state nogoodState, price Money) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	e, ok := g.states[state.hash]
+	if ok && e.is(state) && e.price <= price || !ok && len(g.states) >= maxNogoods {
+		return
+	}
+	g.states[state.hash] = nogood{string(state.bits), state.city, state.day, price}
+}
@@ -0,0 +1,336 @@
+package fsp
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how often scheduler re-evaluates which engines deserve CPU
+const schedTick = 250 * time.Millisecond
+
+// all engines run freely for this long before we start judging them
+const schedWarmup = 1 * time.Second
+
+// constructive engines are considered stalled after no improvement for this long
+const schedStall = 2 * time.Second
+
+// improvement rate decay per tick, older improvements matter less
+const schedDecay = 0.8
+
+// bonus per tick spent paused, so that paused engines get another chance
+const schedAging = 0.05
+
+// number of yields engine gets per turn in deterministic mode
+const detQuantum = 10000
+
+// how long engine with nothing to do sleeps
+const idleWait = 50 * time.Millisecond
+
+// gate is a pause/resume switch engine goroutines pass through when they yield
+type gate struct {
+	nodes     uint64 // effort counters, atomic, keep them 64-bit aligned
+	solutions uint64
+	paused    int32
+	finished  int32
+	stopped   int32
+	m         sync.Mutex
+	c         *sync.Cond
+	quit      chan struct{} // closed when solving is over
+
+	// internal limits of engine are counted in steps instead of time
+	effort bool
+
+	// deterministic mode, engine runs only when it holds the turn
+	turn  chan struct{}
+	over  chan<- int
+	id    int
+	steps int
+}
+
+func newGate() *gate {
+	g := &gate{quit: make(chan struct{})}
+	g.c = sync.NewCond(&g.m)
+	return g
+}
+
+func newTurnGate(id int, over chan<- int) *gate {
+	g := newGate()
+	g.turn = make(chan struct{}, 1)
+	g.over = over
+	g.id = id
+	return g
+}
+
+// blocks for as long as the gate is paused
+func (g *gate) wait() {
+	if g == nil {
+		return
+	}
+	atomic.AddUint64(&g.nodes, 1)
+	if g.turn != nil {
+		g.steps++
+		if g.steps >= detQuantum {
+			g.pass()
+		}
+		return
+	}
+	if atomic.LoadInt32(&g.paused) == 0 {
+		return
+	}
+	g.m.Lock()
+	for atomic.LoadInt32(&g.paused) == 1 {
+		if atomic.LoadInt32(&g.stopped) == 1 {
+			g.m.Unlock()
+			runtime.Goexit()
+		}
+		g.c.Wait()
+	}
+	g.m.Unlock()
+}
+
+func (g *gate) pause() {
+	atomic.StoreInt32(&g.paused, 1)
+}
+
+// solving is over, engine goroutine exits when it passes the gate or
+// talks to the coordinator next time, so that nothing is left running
+func (g *gate) stop() {
+	g.m.Lock()
+	atomic.StoreInt32(&g.stopped, 1)
+	atomic.StoreInt32(&g.paused, 1)
+	close(g.quit)
+	g.c.Broadcast()
+	g.m.Unlock()
+}
+
+// channel closed once solving is over, nil gate never quits
+func (g *gate) quitting() <-chan struct{} {
+	if g == nil {
+		return nil
+	}
+	return g.quit
+}
+
+func (g *gate) resume() {
+	g.m.Lock()
+	atomic.StoreInt32(&g.paused, 0)
+	g.c.Broadcast()
+	g.m.Unlock()
+}
+
+// engine has evaluated full solution
+func (g *gate) solution() {
+	if g != nil {
+		atomic.AddUint64(&g.solutions, 1)
+	}
+}
+
+// engine has nothing to do at the moment
+func (g *gate) idle() {
+	if g != nil && g.turn != nil {
+		g.pass()
+		return
+	}
+	time.Sleep(idleWait)
+}
+
+// wait for the first turn, so no engine runs before it is its turn
+func (g *gate) begin() {
+	if g != nil && g.turn != nil {
+		select {
+		case <-g.turn:
+		case <-g.quit:
+			runtime.Goexit()
+		}
+	}
+}
+
+// hand the turn over to the next engine and wait for another one
+func (g *gate) pass() {
+	g.steps = 0
+	select {
+	case g.over <- g.id:
+	case <-g.quit:
+		runtime.Goexit()
+	}
+	select {
+	case <-g.turn:
+	case <-g.quit:
+		runtime.Goexit()
+	}
+}
+
+func (g *gate) finish() {
+	atomic.StoreInt32(&g.finished, 1)
+	if g.turn != nil {
+		select {
+		case g.over <- g.id:
+		case <-g.quit:
+		}
+	}
+}
+
+func (g *gate) done() bool {
+	return atomic.LoadInt32(&g.finished) == 1
+}
+
+type engineStat struct {
+	improvements int
+	lastImproved time.Time
+	rate         float64 // decayed number of improvements
+	idle         int     // ticks spent paused
+}
+
+// scheduler hands out CPU to engines based on how often they improve best
+// solution; engines that keep failing get paused in favour of better ones,
+// and once constructive engines stall, their cores go to the Polisher
+//
+// in deterministic mode engines run one by one instead, each for detQuantum
+// yields, in the order they were given
+type scheduler struct {
+	engines  []Engine
+	gates    []*gate
+	stats    []engineStat
+	polisher int // index of polisher in engines, -1 if none
+	active   *int32
+	slots    int
+	start    time.Time
+	over     chan int // deterministic mode, engine finished its turn
+	current  int
+	rounds   int
+}
+
+func newScheduler(engines []Engine, polisher Polisher, o Options) *scheduler {
+	s := &scheduler{
+		engines:  engines,
+		gates:    make([]*gate, len(engines)),
+		stats:    make([]engineStat, len(engines)),
+		polisher: -1,
+		active:   polisher.active,
+		slots:    runtime.GOMAXPROCS(0),
+		start:    time.Now(),
+	}
+	if o.Deterministic {
+		s.over = make(chan int)
+	}
+	for i, e := range engines {
+		if o.Deterministic {
+			s.gates[i] = newTurnGate(i, s.over)
+		} else {
+			s.gates[i] = newGate()
+		}
+		// effort budgets are meant to be independent of wall time
+		s.gates[i].effort = o.Deterministic || o.MaxNodes > 0 || o.StallNodes > 0
+		s.stats[i].lastImproved = s.start
+		if _, ok := e.(Polisher); ok {
+			s.polisher = i
+		}
+	}
+	return s
+}
+
+// engine found new best solution
+func (s *scheduler) improved(engine int) {
+	s.stats[engine].improvements++
+	s.stats[engine].rate += 1.0
+	s.stats[engine].lastImproved = time.Now()
+}
+
+// constructive engines haven't found anything better for a while
+func (s *scheduler) stalled() bool {
+	last := s.start
+	for i, st := range s.stats {
+		if i == s.polisher {
+			continue
+		}
+		if st.lastImproved.After(last) {
+			last = st.lastImproved
+		}
+	}
+	return time.Since(last) > schedStall
+}
+
+type byPriority struct {
+	engines []int
+	prio    []float64
+}
+
+func (b byPriority) Len() int {
+	return len(b.engines)
+}
+func (b byPriority) Swap(i, j int) {
+	b.engines[i], b.engines[j] = b.engines[j], b.engines[i]
+}
+func (b byPriority) Less(i, j int) bool {
+	return b.prio[b.engines[i]] > b.prio[b.engines[j]]
+}
+
+func (s *scheduler) reschedule() {
+	if time.Since(s.start) < schedWarmup {
+		return
+	}
+	slots := s.slots
+	if s.stalled() && s.active != nil {
+		// let polisher work on what we have found so far
+		busy := int(atomic.LoadInt32(s.active))
+		slots -= min(busy, slots-1)
+	}
+	candidates := make([]int, 0, len(s.engines))
+	prio := make([]float64, len(s.engines))
+	for i := range s.engines {
+		s.stats[i].rate *= schedDecay
+		if i == s.polisher || s.gates[i].done() {
+			continue
+		}
+		prio[i] = s.stats[i].rate + schedAging*float64(s.stats[i].idle)
+		candidates = append(candidates, i)
+	}
+	// stable, so that portfolio order breaks the ties
+	sort.Stable(byPriority{candidates, prio})
+	for rank, i := range candidates {
+		if rank < slots {
+			if s.stats[i].idle > 0 {
+				printInfo("Scheduler resumes", s.engines[i].Name())
+			}
+			s.stats[i].idle = 0
+			s.gates[i].resume()
+		} else {
+			if s.stats[i].idle == 0 {
+				printInfo("Scheduler pauses", s.engines[i].Name())
+			}
+			s.stats[i].idle++
+			s.gates[i].pause()
+		}
+	}
+}
+
+// pick next engine which has not finished yet, returns false if there is none
+func (s *scheduler) next() bool {
+	for i := 1; i <= len(s.gates); i++ {
+		n := (s.current + i) % len(s.gates)
+		if s.gates[n].done() {
+			continue
+		}
+		if n <= s.current {
+			s.rounds++
+		}
+		s.current = n
+		return true
+	}
+	return false
+}
+
+// give turn to current engine
+func (s *scheduler) grant() {
+	s.gates[s.current].turn <- struct{}{}
+}
+
+// stop all engines, solving is over
+func (s *scheduler) stop() {
+	for _, g := range s.gates {
+		g.stop()
+	}
+}
@@ -3,9 +3,7 @@ package fsp
 import (
 	"fmt"
 	"math"
-	"os"
 	"sort"
-	"strconv"
 	//"github.com/pkg/profile"
 )
 
@@ -13,38 +11,30 @@ import (
 type Sitm struct {
 	graph Graph
 	skip  int
+	state *sitmState
 }
 
-var SitmResultsCounter uint32
-var SitmBranchCounter []uint32
-var sitmCurrentBest = Money(math.MaxInt32)
-
-// engine parms
-var sitmMaxBranches = 0 // default value set in Solve() to graph.size/2
-var sitmDiscountWeight = float32(0.6)
-var sitmMinDiscount = float32(-0.3)
-var sitmDiscountThreshold = Money(650)
+// state of Sitm instances of one run
+type sitmState struct {
+	best *bound
+	// engine parms
+	maxBranches       int
+	discountWeight    float32
+	minDiscount       float32
+	discountThreshold Money
+}
 
-func sitmLoadEnvParams() {
-	var env string
-	env = os.Getenv("SITM_MAX_BRANCHES")
-	if len(env) > 0 {
-		sitmMaxBranches, _ = strconv.Atoi(env)
-	}
-	env = os.Getenv("SITM_DISC_W")
-	if len(env) > 0 {
-		tmp, _ := strconv.ParseFloat(env, 32)
-		sitmDiscountWeight = float32(tmp)
-	}
-	env = os.Getenv("SITM_MIN_DISC")
-	if len(env) > 0 {
-		tmp, _ := strconv.ParseFloat(env, 32)
-		sitmMinDiscount = float32(tmp)
+func newSitmState(g Graph, ps params) *sitmState {
+	maxBranches := g.size / 2
+	if g.size >= 50 {
+		maxBranches = 2
 	}
-	env = os.Getenv("SITM_DISC_THRESH")
-	if len(env) > 0 {
-		tmp, _ := strconv.Atoi(env)
-		sitmDiscountThreshold = Money(tmp)
+	return &sitmState{
+		best:              newBound(),
+		maxBranches:       int(ps.get("SITM_MAX_BRANCHES", float64(maxBranches))),
+		discountWeight:    float32(ps.get("SITM_DISC_W", 0.6)),
+		minDiscount:       float32(ps.get("SITM_MIN_DISC", -0.3)),
+		discountThreshold: Money(ps.get("SITM_DISC_THRESH", 650)),
 	}
 }
 
@@ -54,15 +44,7 @@ func (e Sitm) Name() string {
 
 func (e Sitm) Solve(comm comm, p Problem) {
 	//defer profile.Start(/*profile.MemProfile*/).Stop()
-	sitmLoadEnvParams()
-	if sitmMaxBranches == 0 {
-		sitmMaxBranches = e.graph.size / 2
-		if p.n >= 50 {
-			sitmMaxBranches = 2
-		}
-	}
-	SitmBranchCounter = make([]uint32, e.graph.size+1)
-	sitmSolver(e.graph, p.stats, comm, e.skip)
+	sitmSolver(e.graph, p.stats, e.state, comm, e.skip)
 	//comm.done()
 }
 
@@ -86,10 +68,10 @@ func (f evaluatedCityByValue) Less(i, j int) bool {
 	return f[i].value < f[j].value
 }
 
-func sitmSolver(graph Graph, stats FlightStatistics, comm comm, skip int) /*[]Flight*/ {
+func sitmSolver(graph Graph, stats FlightStatistics, state *sitmState, comm comm, skip int) /*[]Flight*/ {
 
 	printInfo("starting sitm solver", skip)
-	visited := make([]City, 0, MAX_CITIES)
+	visited := make([]City, 0, graph.size)
 	solution := make([]Flight, 0, graph.size)
 	//home := City(0)
 	day := Day(graph.size / 2)
@@ -103,7 +85,7 @@ func sitmSolver(graph Graph, stats FlightStatistics, comm comm, skip int) /*[]Fl
 		// forward
 		//cheapestF := Money(math.MaxInt32)
 		bestDiscF := float32(-math.MaxFloat32)
-		for _, f := range graph.data[i][day] {
+		for _, f := range graph.fromDay(City(i), day) {
 			s := stats.ByDest[f.From][f.To]
 			discount := s.AvgPrice - float32(f.Cost)
 			/*if cheapestF > f.Cost {
@@ -118,7 +100,8 @@ func sitmSolver(graph Graph, stats FlightStatistics, comm comm, skip int) /*[]Fl
 		// backward
 		//cheapestB := Money(math.MaxInt32)
 		bestDiscB := float32(-math.MaxFloat32)
-		for _, f := range graph.toDayData[i][day-1] {
+		for _, fi := range graph.toDay(City(i), day-1) {
+			f := graph.flights[fi]
 			s := stats.ByDest[f.From][f.To]
 			discount := s.AvgPrice - float32(f.Cost)
 			// ignore flight back in price
@@ -151,7 +134,7 @@ func sitmSolver(graph Graph, stats FlightStatistics, comm comm, skip int) /*[]Fl
 		printInfo("City in the middle ", city, i)
 		price := Money(0)
 		sitmIterate(true, solution, day, day-1, city.city, city.city,
-			append(visited, city.city), graph, stats, price, comm, skip)
+			append(visited, city.city), graph, stats, state, price, comm, skip)
 	}
 }
 
@@ -173,24 +156,22 @@ func sitmInsertSortedFlight(slice []EvaluatedFlight, node EvaluatedFlight) []Eva
 }
 
 func sitmIterate(forward bool, partial []Flight, dayF, dayB Day, cityF, cityB City,
-	visited []City, graph Graph, stats FlightStatistics, price Money, comm comm, skip int) {
+	visited []City, graph Graph, stats FlightStatistics, state *sitmState, price Money, comm comm, skip int) {
 
-	if price >= sitmCurrentBest {
+	comm.yield()
+	if price >= state.best.get() {
 		// we have already got worse than best result, give it up, bro
-		SitmResultsCounter++
 		return
 	}
 	if len(partial) == graph.size {
-		SitmResultsCounter++
-		sitmCurrentBest = comm.sendSolution(NewSolution(partial))
+		state.best.set(comm.sendSolution(NewSolution(partial)))
 		return
 	}
 	var currentDeal float32
-	possibleFlights := make([]EvaluatedFlight, 0, MAX_CITIES)
+	possibleFlights := make([]EvaluatedFlight, 0, graph.size)
 	if forward {
 		//printInfo("forward day", dayF, "at", cityF)
-		SitmBranchCounter[dayF] += 1
-		for _, f := range graph.fromDaySortedCost[cityF][dayF] {
+		for _, f := range graph.fromDay(cityF, dayF) {
 			if contains(visited, f.To) {
 				continue
 			}
@@ -199,13 +180,13 @@ func sitmIterate(forward bool, partial []Flight, dayF, dayB Day, cityF, cityB Ci
 				discount := s.AvgPrice - float32(f.Cost)
 				//discount_rate := discount / float32(f.Cost)*/
 			currentDeal = float32(f.Cost) //- 0.6*discount
-			possibleFlights = sitmInsertSortedFlight(possibleFlights, EvaluatedFlight{*f, currentDeal})
+			possibleFlights = sitmInsertSortedFlight(possibleFlights, EvaluatedFlight{f, currentDeal})
 		}
 		dayF++
 	} else { // backward
 		//printInfo("backward day", dayB, "at", cityB)
-		SitmBranchCounter[dayB] += 1
-		for _, f := range graph.toDayData[cityB][dayB] {
+		for _, fi := range graph.toDay(cityB, dayB) {
+			f := &graph.flights[fi]
 			if contains(visited, f.From) {
 				continue
 			}
@@ -215,8 +196,8 @@ func sitmIterate(forward bool, partial []Flight, dayF, dayB Day, cityF, cityB Ci
 		dayB--
 	}
 	//printInfo(possibleFlights)
-	if len(possibleFlights) > sitmMaxBranches {
-		possibleFlights = possibleFlights[:sitmMaxBranches]
+	if len(possibleFlights) > state.maxBranches {
+		possibleFlights = possibleFlights[:state.maxBranches]
 	}
 
 	for _, f := range possibleFlights {
@@ -231,7 +212,7 @@ func sitmIterate(forward bool, partial []Flight, dayF, dayB Day, cityF, cityB Ci
 				f.flight.To,
 				cityB,
 				visited,
-				graph, stats,
+				graph, stats, state,
 				price+f.flight.Cost,
 				comm, skip)
 		} else { // backward
@@ -245,13 +226,13 @@ func sitmIterate(forward bool, partial []Flight, dayF, dayB Day, cityF, cityB Ci
 				cityF,
 				f.flight.From,
 				visited,
-				graph, stats,
+				graph, stats, state,
 				price+f.flight.Cost,
 				comm, skip)
 		}
 
 	}
-	//printInfo("Sitm: no more possible flights, yay!", SitmResultsCounter)
+	//printInfo("Sitm: no more possible flights, yay!")
 
 	return
 }
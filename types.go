@@ -27,7 +27,7 @@ type Flight struct {
 }
 
 type FlightStats struct {
-	FlightCount uint16
+	FlightCount uint32
 	BestPrice   Money
 	BestDay     Day
 	BestDest    City
@@ -41,6 +41,35 @@ type FlightStatistics struct {
 	AvgPrice     float32
 }
 
+// CollectStats of flights between n cities, flights of later days or other
+// cities are left out
+func CollectStats(flights []Flight, n int) FlightStatistics {
+	stats := FlightStatistics{make([][]FlightStats, n), make([][]FlightStats, n), 0, 0}
+	for i := 0; i < n; i++ {
+		stats.ByDest[i] = make([]FlightStats, n)
+		stats.ByDay[i] = make([]FlightStats, n)
+	}
+	add := func(s *FlightStats, f Flight) {
+		if s.FlightCount == 0 || f.Cost < s.BestPrice {
+			s.BestPrice = f.Cost
+			s.BestDay = f.Day
+			s.BestDest = f.To
+		}
+		s.AvgPrice = (s.AvgPrice*float32(s.FlightCount) + float32(f.Cost)) / float32(s.FlightCount+1)
+		s.FlightCount++
+	}
+	for _, f := range flights {
+		if int(f.From) >= n || int(f.To) >= n || int(f.Day) >= n {
+			continue
+		}
+		add(&stats.ByDest[f.From][f.To], f)
+		add(&stats.ByDay[f.From][f.Day], f)
+		stats.AvgPrice = (stats.AvgPrice*float32(stats.TotalFlights) + float32(f.Cost)) / float32(stats.TotalFlights+1)
+		stats.TotalFlights++
+	}
+	return stats
+}
+
 type Problem struct {
 	flights []Flight
 	start   City
@@ -50,7 +79,7 @@ type Problem struct {
 }
 
 func (p Problem) Solve(timeout <-chan time.Time) (Solution, error) {
-	sol, err := kickTheEngines(p, timeout)
+	sol, _, err := p.SolveWithOptions(timeout, Options{})
 	/*for _, f := range p.flights {
 	    if f.Penalty != 0 {
 	        printInfo(f)
@@ -59,6 +88,10 @@ func (p Problem) Solve(timeout <-chan time.Time) (Solution, error) {
 	return sol, err
 }
 
+func (p Problem) GetFlights() []Flight {
+	return p.flights
+}
+
 func (p Problem) FlightsCnt() int {
 	return len(p.flights)
 }
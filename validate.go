@@ -0,0 +1,81 @@
+package fsp
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ValidationError tells which flight of solution is wrong and why,
+// Day is -1 when the error is not about a particular flight
+type ValidationError struct {
+	Day    int
+	From   City
+	To     City
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Day < 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("day %d, flight %d -> %d: %s", e.Day, e.From, e.To, e.Reason)
+}
+
+func invalid(f Flight, reason string, args ...interface{}) error {
+	return &ValidationError{int(f.Day), f.From, f.To, fmt.Sprintf(reason, args...)}
+}
+
+// Validate checks that solution starts and ends in start city, flies
+// exactly once a day, visits every other city once and uses only flights
+// of the problem
+func (p Problem) Validate(s Solution) error {
+	if len(s.flights) != p.n {
+		return &ValidationError{-1, 0, 0, fmt.Sprintf("%d flights in solution, expected %d", len(s.flights), p.n)}
+	}
+	flights := make([]Flight, len(s.flights))
+	copy(flights, s.flights)
+	sort.Sort(ByDay(flights))
+	visited := make([]bool, p.n)
+	current := p.start
+	for i, f := range flights {
+		switch {
+		case int(f.Day) != i:
+			return invalid(f, "expected flight on day %d", i)
+		case f.From != current:
+			return invalid(f, "departs from %d, we are in %d", f.From, current)
+		case int(f.To) >= p.n:
+			return invalid(f, "unknown city %d", f.To)
+		case f.To == p.start && i != p.n-1:
+			return invalid(f, "returns to start before the last day")
+		case f.To != p.start && i == p.n-1:
+			return invalid(f, "does not return to start")
+		case visited[f.To]:
+			return invalid(f, "city %d visited twice", f.To)
+		}
+		visited[f.To] = true
+		current = f.To
+	}
+	if Cost(flights) != s.totalCost {
+		return &ValidationError{-1, 0, 0, fmt.Sprintf("total cost %d, flights cost %d", s.totalCost, Cost(flights))}
+	}
+
+	// one pass over all flights of the problem, there may be millions
+	missing := make(map[Flight]bool, len(flights))
+	for _, f := range flights {
+		missing[flightKey(f)] = true
+	}
+	for _, f := range p.flights {
+		delete(missing, flightKey(f))
+	}
+	for _, f := range flights {
+		if missing[flightKey(f)] {
+			return invalid(f, "no such flight for %d", f.Cost)
+		}
+	}
+	return nil
+}
+
+// flight without engine specific fields
+func flightKey(f Flight) Flight {
+	return Flight{From: f.From, To: f.To, Day: f.Day, Cost: f.Cost}
+}